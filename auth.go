@@ -0,0 +1,267 @@
+package tea
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+const (
+	// BasicAuth is the credential kind for basic auth usernames
+	BasicAuth = "basic"
+
+	// APIKeyAuth is the credential kind for API keys
+	APIKeyAuth = "apikey"
+)
+
+// AuthOptions is a policy for authenticating requests with credentials
+type AuthOptions struct {
+	// Store is the store of credentials to authenticate against
+	Store CredentialStore
+
+	// Realm is the protection space sent in challenges (default restricted)
+	Realm string
+
+	// Basic accepts basic auth credentials (default if no API key header or query param is set)
+	Basic bool
+
+	// APIKeyHeader is the header API keys are read from (e.g., X-API-Key)
+	APIKeyHeader string
+
+	// APIKeyQuery is the query param API keys are read from (e.g., api_key)
+	APIKeyQuery string
+}
+
+// AuthMiddleware is an implementation of the credential authentication middleware
+// exposing the Claims of the credential through the request context
+type AuthMiddleware struct {
+	opts AuthOptions
+}
+
+// Handle provides an http handler for authenticating requests
+func (m AuthMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := m.authenticate(r)
+		if err != nil {
+			if trail.IsNotAuthorized(err) {
+				for _, challenge := range m.challenges() {
+					w.Header().Add("WWW-Authenticate", challenge)
+				}
+			}
+
+			Send(w, r, err)
+			return
+		}
+
+		if req := trail.RequestFromContext(r.Context()); req != nil {
+			if userId, err := uuid.Parse(claims.Subject()); err == nil {
+				req.SetUserId(userId)
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims)))
+	})
+}
+
+// authenticate checks the credentials of the request
+func (m AuthMiddleware) authenticate(r *http.Request) (Claims, error) {
+	if m.opts.Basic {
+		if username, password, ok := r.BasicAuth(); ok {
+			claims, err := m.verify(r.Context(), BasicAuth, username, password)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, present := claims["sub"]; !present {
+				claims["sub"] = username
+			}
+
+			return claims, nil
+		}
+	}
+
+	var key string
+	if m.opts.APIKeyHeader != "" {
+		key = r.Header.Get(m.opts.APIKeyHeader)
+	}
+
+	if key == "" && m.opts.APIKeyQuery != "" {
+		key = r.URL.Query().Get(m.opts.APIKeyQuery)
+	}
+
+	if key != "" {
+		return m.verify(r.Context(), APIKeyAuth, "", key)
+	}
+
+	return nil, trail.NewErrorNotAuthorized("missing credentials")
+}
+
+// verify checks the secret against the store, returning a copy of the claims of the principal
+func (m AuthMiddleware) verify(ctx context.Context, kind, id, secret string) (Claims, error) {
+	principal, err := m.opts.Store.Verify(ctx, kind, id, secret)
+	if err != nil {
+		if trail.IsNotAuthorized(err) || trail.IsNotFound(err) {
+			return nil, trail.NewErrorNotAuthorized("bad credentials")
+		}
+
+		return nil, trail.Stacktrace(err)
+	}
+
+	claims := make(Claims, len(principal)+1)
+	for key, value := range principal {
+		claims[key] = value
+	}
+
+	return claims, nil
+}
+
+// challenges gets the WWW-Authenticate challenges for the accepted credentials
+func (m AuthMiddleware) challenges() []string {
+	var challenges []string
+	if m.opts.Basic {
+		challenges = append(challenges, fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, m.opts.Realm))
+	}
+
+	if m.opts.APIKeyHeader != "" || m.opts.APIKeyQuery != "" {
+		challenge := fmt.Sprintf(`APIKey realm=%q`, m.opts.Realm)
+		if m.opts.APIKeyHeader != "" {
+			challenge += fmt.Sprintf(`, header=%q`, m.opts.APIKeyHeader)
+		}
+
+		if m.opts.APIKeyQuery != "" {
+			challenge += fmt.Sprintf(`, query=%q`, m.opts.APIKeyQuery)
+		}
+
+		challenges = append(challenges, challenge)
+	}
+
+	return challenges
+}
+
+// NewAuthMiddleware constructs a new middleware that authenticates requests with basic auth or API keys
+// it panics if no store is set
+// e.g., r.Use(NewAuthMiddleware(AuthOptions{Store: store, Basic: true, APIKeyHeader: "X-API-Key"}))
+func NewAuthMiddleware(opts AuthOptions) AuthMiddleware {
+	if opts.Store == nil {
+		panic("tea: auth middleware requires a credential store")
+	}
+
+	if opts.Realm == "" {
+		opts.Realm = "restricted"
+	}
+
+	if opts.APIKeyHeader == "" && opts.APIKeyQuery == "" {
+		opts.Basic = true
+	}
+
+	return AuthMiddleware{opts: opts}
+}
+
+// CredentialStore is a store of credentials for authenticating requests
+type CredentialStore interface {
+	// Verify checks the secret of a principal, returning the claims of the principal (e.g., sub, scope or roles)
+	// or a not authorized error for unknown principals and bad secrets
+	// basic auth principals are identified by username and API keys are verified with an empty id
+	// secrets should be compared in constant time or stored with a password hash (e.g., bcrypt or argon2)
+	Verify(ctx context.Context, kind, id, secret string) (Claims, error)
+}
+
+// MemoryCredentialStore is an in-memory implementation of the credential store
+// secrets are kept as salted HMAC-SHA256 digests, basic auth principals have the username as the subject unless set
+type MemoryCredentialStore struct {
+	mutex       sync.RWMutex
+	key         []byte
+	credentials map[string]memoryCredential
+	missing     memoryCredential
+}
+
+// Verify checks the secret of a principal by kind and id
+func (s *MemoryCredentialStore) Verify(_ context.Context, kind, id, secret string) (Claims, error) {
+	if kind == APIKeyAuth {
+		id = s.keyId(secret)
+	}
+
+	s.mutex.RLock()
+	credential, present := s.credentials[kind+":"+id]
+	s.mutex.RUnlock()
+	if !present {
+		// missing principals are checked against a dummy credential so they take as long as bad secrets
+		credential = s.missing
+	}
+
+	if subtle.ConstantTimeCompare(credential.digest(secret), credential.secret) != 1 || !present {
+		return nil, trail.NewErrorNotAuthorized("bad credentials")
+	}
+
+	return credential.claims, nil
+}
+
+// AddUser adds a basic auth user with the claims
+func (s *MemoryCredentialStore) AddUser(username, password string, claims Claims) {
+	s.add(BasicAuth, username, password, claims)
+}
+
+// AddAPIKey adds an API key with the claims
+func (s *MemoryCredentialStore) AddAPIKey(key string, claims Claims) {
+	s.add(APIKeyAuth, s.keyId(key), key, claims)
+}
+
+// add adds a credential
+func (s *MemoryCredentialStore) add(kind, id, secret string, claims Claims) {
+	credential := newMemoryCredential(secret)
+	credential.claims = claims
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.credentials[kind+":"+id] = credential
+}
+
+// keyId gets the id of an API key
+func (s *MemoryCredentialStore) keyId(key string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewMemoryCredentialStore creates a new in-memory credential store
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return &MemoryCredentialStore{
+		key:         key,
+		credentials: make(map[string]memoryCredential),
+		missing:     newMemoryCredential(""),
+	}
+}
+
+// memoryCredential is a stored secret of a principal
+type memoryCredential struct {
+	salt   []byte
+	secret []byte
+	claims Claims
+}
+
+// digest gets the salted digest of a secret
+func (c memoryCredential) digest(secret string) []byte {
+	mac := hmac.New(sha256.New, c.salt)
+	mac.Write([]byte(secret))
+	return mac.Sum(nil)
+}
+
+// newMemoryCredential creates a new stored secret with a random salt
+func newMemoryCredential(secret string) memoryCredential {
+	c := memoryCredential{salt: make([]byte, 16)}
+	_, _ = rand.Read(c.salt)
+	c.secret = c.digest(secret)
+	return c
+}
@@ -0,0 +1,166 @@
+package tea
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// failingCredentialStore is a credential store that is unavailable
+type failingCredentialStore struct{}
+
+func (failingCredentialStore) Verify(context.Context, string, string, string) (Claims, error) {
+	return nil, trail.NewErrorServiceUnavailable("store unavailable")
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Parallel()
+
+	userId := uuid.New()
+	store := NewMemoryCredentialStore()
+	store.AddUser("demo", "p@55w0rd", nil)
+	store.AddUser("admin", "secret", Claims{"sub": userId.String(), "roles": []string{"admin"}})
+	store.AddAPIKey("key", Claims{"sub": "service", "scope": "orders:read"})
+
+	serve := func(m AuthMiddleware, req *http.Request) (*httptest.ResponseRecorder, Claims) {
+		var claims Claims
+		w := httptest.NewRecorder()
+		m.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims = ClaimsFromContext(r.Context())
+			Send(w, r, nil)
+		})).ServeHTTP(w, req)
+		return w, claims
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		m := NewAuthMiddleware(AuthOptions{Store: store})
+		assert.Equal(t, "restricted", m.opts.Realm)
+		assert.True(t, m.opts.Basic)
+		assert.False(t, NewAuthMiddleware(AuthOptions{Store: store, APIKeyHeader: "X-API-Key"}).opts.Basic)
+		assert.Panics(t, func() { NewAuthMiddleware(AuthOptions{}) })
+	})
+
+	t.Run("authenticates basic auth", func(t *testing.T) {
+		m := NewAuthMiddleware(AuthOptions{Store: store, Realm: "orders"})
+		req := httptest.NewRequest("GET", "/tests", nil)
+		req.SetBasicAuth("demo", "p@55w0rd")
+		w, claims := serve(m, req)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "demo", claims.Subject())
+
+		for _, credentials := range [][2]string{{"demo", "password"}, {"unknown", "p@55w0rd"}, {"", ""}} {
+			req := httptest.NewRequest("GET", "/tests", nil)
+			req.SetBasicAuth(credentials[0], credentials[1])
+			w, _ := serve(m, req)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Equal(t, `Basic realm="orders", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+		}
+
+		w, _ = serve(m, httptest.NewRequest("GET", "/tests", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, `Basic realm="orders", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("authenticates API keys", func(t *testing.T) {
+		m := NewAuthMiddleware(AuthOptions{Store: store, Basic: true, APIKeyHeader: "X-API-Key", APIKeyQuery: "api_key"})
+		req := httptest.NewRequest("GET", "/tests", nil)
+		req.Header.Set("X-API-Key", "key")
+		w, claims := serve(m, req)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "service", claims.Subject())
+		assert.Equal(t, "orders:read", claims["scope"])
+
+		w, claims = serve(m, httptest.NewRequest("GET", "/tests?api_key=key", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "service", claims.Subject())
+
+		req = httptest.NewRequest("GET", "/tests?api_key=key", nil)
+		req.Header.Set("X-API-Key", "bad")
+		w, _ = serve(m, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, []string{
+			`Basic realm="restricted", charset="UTF-8"`,
+			`APIKey realm="restricted", header="X-API-Key", query="api_key"`,
+		}, w.Header().Values("WWW-Authenticate"))
+
+		// basic auth is not accepted unless enabled
+		m = NewAuthMiddleware(AuthOptions{Store: store, APIKeyHeader: "X-API-Key"})
+		req = httptest.NewRequest("GET", "/tests", nil)
+		req.SetBasicAuth("demo", "p@55w0rd")
+		w, _ = serve(m, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, `APIKey realm="restricted", header="X-API-Key"`, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("sets the trail user", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Use(NewAuthMiddleware(AuthOptions{Store: store}))
+		r.Route("GET", "/tests", func(w http.ResponseWriter, r *http.Request) {
+			var value struct {
+				UserId   uuid.UUID `claims:"sub"`
+				Roles    []string  `claims:"roles"`
+				Username string    `auth:"username"`
+			}
+
+			assert.Nil(t, Parse(w, r, &value))
+			assert.Equal(t, userId, value.UserId)
+			assert.Equal(t, []string{"admin"}, value.Roles)
+			assert.Equal(t, "admin", value.Username)
+			assert.Equal(t, &userId, trail.RequestFromContext(r.Context()).UserId())
+			Send(w, r, nil)
+		})
+
+		req := httptest.NewRequest("GET", "/v0/tests", nil)
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("store failure", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/tests", nil)
+		req.SetBasicAuth("demo", "p@55w0rd")
+		w, _ := serve(NewAuthMiddleware(AuthOptions{Store: failingCredentialStore{}}), req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Empty(t, w.Header().Get("WWW-Authenticate"))
+	})
+}
+
+func TestMemoryCredentialStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemoryCredentialStore()
+	store.AddUser("demo", "p@55w0rd", Claims{"sub": "demo"})
+	store.AddAPIKey("key", Claims{"sub": "service"})
+
+	claims, err := store.Verify(ctx, BasicAuth, "demo", "p@55w0rd")
+	assert.Nil(t, err)
+	assert.Equal(t, "demo", claims.Subject())
+
+	claims, err = store.Verify(ctx, APIKeyAuth, "", "key")
+	assert.Nil(t, err)
+	assert.Equal(t, "service", claims.Subject())
+
+	for _, credentials := range [][3]string{
+		{BasicAuth, "demo", "password"},
+		{BasicAuth, "unknown", "p@55w0rd"},
+		{BasicAuth, "unknown", ""},
+		{BasicAuth, "", "key"},
+		{APIKeyAuth, "", "p@55w0rd"},
+		{APIKeyAuth, "demo", "p@55w0rd"},
+	} {
+		_, err := store.Verify(ctx, credentials[0], credentials[1], credentials[2])
+		assert.True(t, trail.IsNotAuthorized(err), credentials)
+	}
+
+	// secrets are salted per principal
+	store.AddUser("other", "p@55w0rd", nil)
+	assert.NotEqual(t, store.credentials["basic:demo"].secret, store.credentials["basic:other"].secret)
+}
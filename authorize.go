@@ -0,0 +1,190 @@
+package tea
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// RequireMiddleware is an implementation of the authorization middleware
+// checking the claims attached to the request context by an authenticator (e.g., JWTMiddleware or AuthMiddleware)
+// requests without claims are rejected with 401 Unauthorized and requests missing scopes or roles with 403 Forbidden
+type RequireMiddleware struct {
+	scopes     []string
+	roles      []string
+	challenges []string
+}
+
+// Handle provides an http handler for authorizing requests
+func (m RequireMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := ClaimsFromContext(r.Context())
+		if claims == nil {
+			challenges := m.challenges
+			if len(challenges) == 0 {
+				challenges = []string{"Bearer"}
+			}
+
+			for _, challenge := range challenges {
+				w.Header().Add("WWW-Authenticate", challenge)
+			}
+
+			Send(w, r, trail.NewErrorNotAuthorized("authentication required"))
+			return
+		}
+
+		if missing := missingScopes(claims, m.scopes); len(missing) > 0 {
+			Send(w, r, trail.NewErrorForbidden(fmt.Sprintf("missing scopes %s", strings.Join(missing, " "))))
+			return
+		}
+
+		if len(m.roles) > 0 && !hasRole(claims, m.roles) {
+			Send(w, r, trail.NewErrorForbidden(fmt.Sprintf("requires role %s", strings.Join(m.roles, " or "))))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Challenge sets the WWW-Authenticate challenges sent when requests are not authenticated (default Bearer)
+// e.g., tea.RequireRoles("admin").Challenge(`Basic realm="restricted", charset="UTF-8"`)
+func (m RequireMiddleware) Challenge(challenges ...string) RequireMiddleware {
+	m.challenges = challenges
+	return m
+}
+
+// RequireScopes creates a middleware requiring all of the scopes
+// scopes are read from the space delimited scope claim or the scp claim
+// e.g., r.Route("POST", "/orders", handler, tea.RequireScopes("orders:write"))
+func RequireScopes(scopes ...string) RequireMiddleware {
+	return RequireMiddleware{scopes: scopes}
+}
+
+// RequireRoles creates a middleware requiring any of the roles
+// roles are read from the roles claim
+// e.g., r.Group("/admin", tea.RequireRoles("admin", "support"))
+func RequireRoles(roles ...string) RequireMiddleware {
+	return RequireMiddleware{roles: roles}
+}
+
+// missingScopes gets the required scopes missing from the claims
+func missingScopes(claims Claims, required []string) []string {
+	granted := make(map[string]struct{})
+	for _, key := range []string{"scope", "scp"} {
+		for _, scope := range claimValues(claims[key]) {
+			granted[scope] = struct{}{}
+		}
+	}
+
+	var missing []string
+	for _, scope := range required {
+		if _, present := granted[scope]; !present {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
+
+// hasRole checks whether the claims have any of the roles
+func hasRole(claims Claims, roles []string) bool {
+	for _, granted := range claimValues(claims["roles"]) {
+		for _, role := range roles {
+			if granted == role {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// claimValues gets the values of a space delimited string or list claim
+func claimValues(claim interface{}) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []string:
+		return claim
+	case []interface{}:
+		var values []string
+		for _, v := range claim {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	}
+
+	return nil
+}
+
+// RouteInfo is the documentation of a route served by a router
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	// Scopes are the scopes required by the route
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Roles are the roles required by the route (any role of each set)
+	Roles [][]string `json:"roles,omitempty"`
+}
+
+// Routes gets the routes served by the router (ordered by path and method)
+// including the scopes and roles required by router, group and route middleware
+func (r *Router) Routes() []RouteInfo {
+	var routes []RouteInfo
+	for _, route := range r.root().documented {
+		info := RouteInfo{Method: route.method, Path: r.root().servicePrefix + route.template}
+		var requirements []RequireMiddleware
+		for _, phase := range []middlewarePhase{preRouting, postRouting} {
+			for _, m := range route.router.chain(phase) {
+				if rm, ok := m.Middleware.(RequireMiddleware); ok && !m.excludes(route.template) {
+					requirements = append(requirements, rm)
+				}
+			}
+		}
+
+		requirements = append(requirements, route.requirements...)
+
+		seen := make(map[string]struct{})
+		for _, m := range requirements {
+			for _, scope := range m.scopes {
+				if _, present := seen[scope]; !present {
+					seen[scope] = struct{}{}
+					info.Scopes = append(info.Scopes, scope)
+				}
+			}
+
+			if len(m.roles) > 0 {
+				info.Roles = append(info.Roles, m.roles)
+			}
+		}
+
+		routes = append(routes, info)
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+
+		return routes[i].Method < routes[j].Method
+	})
+
+	return routes
+}
+
+// documentedRoute is a route registered on a router
+type documentedRoute struct {
+	method       string
+	template     string
+	router       *Router
+	requirements []RequireMiddleware
+}
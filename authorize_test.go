@@ -0,0 +1,95 @@
+package tea
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireMiddleware(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("a secret key")
+	token := func(claims map[string]interface{}) string {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		return signToken(t, "HS256", "", secret, claims)
+	}
+
+	r := NewRouter("0")
+	r.Use(NewJWTMiddleware(JWTOptions{Secret: secret}), Except("/health/status"))
+	r.Route("POST", "/orders", func(w http.ResponseWriter, r *http.Request) {
+		Send(w, r, nil)
+	}, RequireScopes("orders:read", "orders:write"))
+
+	admin := r.Group("/admin", RequireRoles("admin", "support"))
+	admin.Route("DELETE", "/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		Send(w, r, nil)
+	}, RequireScopes("orders:write"))
+
+	for name, test := range map[string]struct {
+		method string
+		path   string
+		claims map[string]interface{}
+		status int
+	}{
+		"scope claim":        {"POST", "/v0/orders", map[string]interface{}{"scope": "orders:read orders:write"}, http.StatusNoContent},
+		"scp claim":          {"POST", "/v0/orders", map[string]interface{}{"scp": []string{"orders:read", "orders:write"}}, http.StatusNoContent},
+		"split claims":       {"POST", "/v0/orders", map[string]interface{}{"scope": "orders:read", "scp": "orders:write"}, http.StatusNoContent},
+		"missing scope":      {"POST", "/v0/orders", map[string]interface{}{"scope": "orders:read"}, http.StatusForbidden},
+		"no scopes":          {"POST", "/v0/orders", map[string]interface{}{"sub": "demo"}, http.StatusForbidden},
+		"role":               {"DELETE", "/v0/admin/orders/1", map[string]interface{}{"roles": []string{"support"}, "scope": "orders:write"}, http.StatusNoContent},
+		"role string":        {"DELETE", "/v0/admin/orders/1", map[string]interface{}{"roles": "admin", "scope": "orders:write"}, http.StatusNoContent},
+		"missing role":       {"DELETE", "/v0/admin/orders/1", map[string]interface{}{"roles": []string{"user"}, "scope": "orders:write"}, http.StatusForbidden},
+		"role missing scope": {"DELETE", "/v0/admin/orders/1", map[string]interface{}{"roles": []string{"admin"}}, http.StatusForbidden},
+		"not authenticated":  {"POST", "/v0/orders", nil, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.claims != nil {
+			req.Header.Set("Authorization", "Bearer "+token(test.claims))
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, test.status, w.Code, name)
+	}
+
+	t.Run("requires authentication", func(t *testing.T) {
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Send(w, r, nil)
+		})
+
+		w := httptest.NewRecorder()
+		RequireScopes("orders:read").Handle(ok).ServeHTTP(w, httptest.NewRequest("GET", "/tests", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+		w = httptest.NewRecorder()
+		basic := `Basic realm="restricted", charset="UTF-8"`
+		RequireRoles("admin").Challenge(basic).Handle(ok).ServeHTTP(w, httptest.NewRequest("GET", "/tests", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, []string{basic}, w.Header().Values("WWW-Authenticate"))
+	})
+
+	t.Run("lists requirements", func(t *testing.T) {
+		r := NewRouter("0", WithServicePrefix("/orders"))
+		r.Use(RequireScopes("orders:read"), Except("/health/status"))
+		r.Route("GET", "/", func(w http.ResponseWriter, r *http.Request) {})
+		r.Route("POST", "/", func(w http.ResponseWriter, r *http.Request) {}, RequireScopes("orders:read", "orders:write"))
+
+		admin := r.Group("/admin", RequireRoles("admin", "support"))
+		admin.Route("DELETE", "/{id}", func(w http.ResponseWriter, r *http.Request) {}, RequireRoles("owner"))
+		admin.Use(RequireScopes("admin"))
+
+		assert.Equal(t, []RouteInfo{
+			{Method: "GET", Path: "/orders/health/status"},
+			{Method: "GET", Path: "/orders/v0/", Scopes: []string{"orders:read"}},
+			{Method: "POST", Path: "/orders/v0/", Scopes: []string{"orders:read", "orders:write"}},
+			{Method: "DELETE", Path: "/orders/v0/admin/{id}", Scopes: []string{"orders:read", "admin"}, Roles: [][]string{{"admin", "support"}, {"owner"}}},
+		}, r.Routes())
+
+		assert.Equal(t, r.Routes(), admin.Routes())
+	})
+}
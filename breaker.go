@@ -0,0 +1,166 @@
+package tea
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pghq/go-tea/health"
+)
+
+const (
+	// CircuitClosed represents a circuit allowing requests to the upstream
+	CircuitClosed CircuitState = "closed"
+
+	// CircuitOpen represents a circuit rejecting requests to the upstream
+	CircuitOpen CircuitState = "open"
+
+	// CircuitHalfOpen represents a circuit allowing a trial request to the upstream
+	CircuitHalfOpen CircuitState = "halfOpen"
+)
+
+// CircuitState is the state of a circuit breaker
+type CircuitState string
+
+// CircuitBreakerOptions is a circuit breaker policy for the upstreams of a director
+type CircuitBreakerOptions struct {
+	// Failures is the number of consecutive failures which opens the circuit (default 5)
+	Failures int `yaml:"failures"`
+
+	// Cooldown is how long the circuit stays open before a trial request is allowed (default 30s)
+	Cooldown time.Duration `yaml:"cooldown"`
+
+	// Statuses are the upstream response statuses counted as failures (default all 5xx statuses)
+	// upstream transport errors and timeouts always count, requests cancelled by clients never do
+	Statuses []int `yaml:"statuses"`
+}
+
+// fails checks whether an upstream response status counts as a failure
+func (o CircuitBreakerOptions) fails(status int) bool {
+	if len(o.Statuses) == 0 {
+		return status >= 500
+	}
+
+	for _, s := range o.Statuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// circuitBreaker stops sending requests to a failing upstream
+type circuitBreaker struct {
+	opts     CircuitBreakerOptions
+	now      func() time.Time
+	mutex    sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// ready checks whether the circuit would allow a request
+func (b *circuitBreaker) ready() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		return b.now().Sub(b.openedAt) >= b.opts.Cooldown
+	case CircuitHalfOpen:
+		return !b.trial
+	}
+
+	return true
+}
+
+// acquire checks whether the circuit allows a request, starting a trial if the cooldown has passed
+func (b *circuitBreaker) acquire() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.opts.Cooldown {
+		b.state = CircuitHalfOpen
+		b.trial = false
+	}
+
+	switch b.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if b.trial {
+			return false
+		}
+
+		b.trial = true
+	}
+
+	return true
+}
+
+// success records a successful request, closing the circuit
+func (b *circuitBreaker) success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.state = CircuitClosed
+	b.failures = 0
+	b.trial = false
+}
+
+// failure records a failed request, opening the circuit if the trial or too many requests failed
+func (b *circuitBreaker) failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.opts.Failures {
+		b.state = CircuitOpen
+		b.openedAt = b.now()
+		b.trial = false
+	}
+}
+
+// abort records a request that ended without an outcome (e.g., cancelled by the client)
+// allowing another trial if it was the trial request
+func (b *circuitBreaker) abort() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.trial = false
+}
+
+// State gets the current state of the circuit
+func (b *circuitBreaker) State() CircuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+// check reports the state of the circuit as a health check
+func (b *circuitBreaker) check(observedAt time.Time) *health.Check {
+	state := b.State()
+	c := health.NewHealthyCheck(observedAt, state, "circuit")
+	if state != CircuitClosed {
+		c.Status = health.StatusUnhealthy
+	}
+
+	return c
+}
+
+// newCircuitBreaker creates a new closed circuit breaker
+func newCircuitBreaker(opts CircuitBreakerOptions) *circuitBreaker {
+	if opts.Failures < 1 {
+		opts.Failures = 5
+	}
+
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 30 * time.Second
+	}
+
+	return &circuitBreaker{
+		opts:  opts,
+		now:   time.Now,
+		state: CircuitClosed,
+	}
+}
@@ -0,0 +1,87 @@
+package tea
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pghq/go-tea/health"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	t.Run("defaults", func(t *testing.T) {
+		b := newCircuitBreaker(CircuitBreakerOptions{})
+		assert.Equal(t, 5, b.opts.Failures)
+		assert.Equal(t, 30*time.Second, b.opts.Cooldown)
+	})
+
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		now := time.Now()
+		b := newCircuitBreaker(CircuitBreakerOptions{Failures: 2, Cooldown: time.Minute})
+		b.now = func() time.Time { return now }
+
+		assert.True(t, b.acquire())
+		b.failure()
+		b.success()
+		b.failure()
+		assert.Equal(t, CircuitClosed, b.State())
+		assert.Equal(t, health.StatusHealthy, b.check(now).Status)
+
+		b.failure()
+		assert.Equal(t, CircuitOpen, b.State())
+		assert.False(t, b.ready())
+		assert.False(t, b.acquire())
+		assert.Equal(t, health.StatusUnhealthy, b.check(now).Status)
+		assert.Equal(t, CircuitOpen, b.check(now).Value)
+	})
+
+	t.Run("half open trial", func(t *testing.T) {
+		now := time.Now()
+		b := newCircuitBreaker(CircuitBreakerOptions{Failures: 1, Cooldown: time.Minute})
+		b.now = func() time.Time { return now }
+		b.failure()
+
+		now = now.Add(time.Minute)
+		assert.True(t, b.ready())
+		assert.True(t, b.acquire())
+		assert.Equal(t, CircuitHalfOpen, b.State())
+		assert.False(t, b.ready())
+		assert.False(t, b.acquire())
+
+		b.failure()
+		assert.Equal(t, CircuitOpen, b.State())
+
+		now = now.Add(time.Minute)
+		assert.True(t, b.acquire())
+		b.success()
+		assert.Equal(t, CircuitClosed, b.State())
+		assert.True(t, b.acquire())
+	})
+
+	t.Run("aborted trial", func(t *testing.T) {
+		now := time.Now()
+		b := newCircuitBreaker(CircuitBreakerOptions{Failures: 1, Cooldown: time.Minute})
+		b.now = func() time.Time { return now }
+		b.failure()
+
+		now = now.Add(time.Minute)
+		assert.True(t, b.acquire())
+		assert.False(t, b.acquire())
+		b.abort()
+		assert.Equal(t, CircuitHalfOpen, b.State())
+		assert.True(t, b.acquire())
+	})
+
+	t.Run("failure statuses", func(t *testing.T) {
+		assert.True(t, CircuitBreakerOptions{}.fails(http.StatusInternalServerError))
+		assert.False(t, CircuitBreakerOptions{}.fails(http.StatusNotFound))
+
+		opts := CircuitBreakerOptions{Statuses: []int{http.StatusServiceUnavailable}}
+		assert.True(t, opts.fails(http.StatusServiceUnavailable))
+		assert.False(t, opts.fails(http.StatusInternalServerError))
+	})
+}
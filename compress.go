@@ -0,0 +1,320 @@
+package tea
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionOptions is a policy for compressing responses
+type CompressionOptions struct {
+	// Encodings is the supported content encodings in order of preference
+	// (defaults to zstd, br, gzip and deflate)
+	Encodings []string
+
+	// MinSize is the minimum size of compressed responses in bytes (default 1024)
+	// streamed responses are compressed once flushed regardless of size
+	MinSize int
+
+	// ContentTypes is the compressible media types, a trailing * matches any subtype
+	// (defaults to text/*, JSON, XML, JavaScript and SVG)
+	ContentTypes []string
+}
+
+// compressible checks whether responses of the content type may be compressed
+func (o CompressionOptions) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range o.ContentTypes {
+		if pattern == mediaType || strings.HasSuffix(pattern, "*") && strings.HasPrefix(mediaType, pattern[:len(pattern)-1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encoder is a streaming content encoder
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// CompressionMiddleware is an implementation of the response compression middleware
+// negotiating the content encoding with the Accept-Encoding header
+type CompressionMiddleware struct {
+	opts     CompressionOptions
+	encoders map[string]*sync.Pool
+}
+
+// Handle provides an http handler for compressing responses
+func (m CompressionMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := compressWriter{ResponseWriter: w, m: m, encoding: m.negotiate(r.Header.Get("Accept-Encoding"))}
+		defer cw.close()
+		next.ServeHTTP(&cw, r)
+	})
+}
+
+// negotiate selects the preferred encoding acceptable to the client (if any)
+func (m CompressionMiddleware) negotiate(acceptEncoding string) string {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	var selected string
+	var best float64
+	for _, encoding := range m.opts.Encodings {
+		q, present := accepted[encoding]
+		if !present {
+			q = accepted["*"]
+		}
+
+		if q > best {
+			selected, best = encoding, q
+		}
+	}
+
+	return selected
+}
+
+// NewCompressionMiddleware constructs a new middleware that compresses responses
+// e.g., Router.Use(NewCompressionMiddleware(CompressionOptions{}), PreRouting()) or Proxy.Middleware
+func NewCompressionMiddleware(opts CompressionOptions) CompressionMiddleware {
+	if len(opts.Encodings) == 0 {
+		opts.Encodings = []string{"zstd", "br", "gzip", "deflate"}
+	}
+
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1024
+	}
+
+	if len(opts.ContentTypes) == 0 {
+		opts.ContentTypes = []string{
+			"text/*",
+			"application/json",
+			"application/problem+json",
+			"application/health+json",
+			"application/javascript",
+			"application/xml",
+			"image/svg+xml",
+		}
+	}
+
+	m := CompressionMiddleware{opts: opts, encoders: make(map[string]*sync.Pool)}
+	for _, encoding := range opts.Encodings {
+		var newEncoder func() encoder
+		switch encoding {
+		case "zstd":
+			newEncoder = func() encoder {
+				e, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+				return e
+			}
+		case "br":
+			newEncoder = func() encoder { return brotli.NewWriter(nil) }
+		case "gzip":
+			newEncoder = func() encoder { return gzip.NewWriter(nil) }
+		case "deflate":
+			newEncoder = func() encoder {
+				e, _ := flate.NewWriter(nil, flate.DefaultCompression)
+				return e
+			}
+		default:
+			continue
+		}
+
+		m.encoders[encoding] = &sync.Pool{New: func() interface{} { return newEncoder() }}
+	}
+
+	return m
+}
+
+// compressWriter buffers the start of a response to decide whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	m        CompressionMiddleware
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	encoder  encoder
+}
+
+func (w *compressWriter) WriteHeader(statusCode int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+
+	if statusCode < http.StatusOK {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.status = statusCode
+	if statusCode == http.StatusNoContent || statusCode == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.m.opts.MinSize {
+			if err := w.decide(true); err != nil {
+				return 0, err
+			}
+		}
+
+		return len(b), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client, compressing streamed responses regardless of size
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+
+		_ = w.decide(true)
+	}
+
+	if w.encoder != nil {
+		_ = w.encoder.Flush()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection (e.g., for WebSockets)
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok && !w.decided {
+		w.decided = true
+		return hj.Hijack()
+	}
+
+	return nil, nil, http.ErrNotSupported
+}
+
+// Push initiates an HTTP/2 server push
+func (w *compressWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+// Unwrap gets the underlying response writer (e.g., for http.ResponseController)
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide writes the response header, compressing the response if it is eligible
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	eligible := header.Get("Content-Encoding") == "" &&
+		header.Get("Content-Range") == "" &&
+		w.status != http.StatusPartialContent &&
+		w.m.opts.compressible(header.Get("Content-Type"))
+
+	if eligible {
+		vary := false
+		for _, v := range header.Values("Vary") {
+			for _, field := range strings.Split(v, ",") {
+				field = strings.TrimSpace(field)
+				vary = vary || field == "*" || strings.EqualFold(field, "Accept-Encoding")
+			}
+		}
+
+		if !vary {
+			header.Add("Vary", "Accept-Encoding")
+		}
+	}
+
+	if pool := w.m.encoders[w.encoding]; eligible && compress && pool != nil && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		w.encoder = pool.Get().(encoder)
+		w.encoder.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+
+	return err
+}
+
+// close finishes the response once the handler returns
+func (w *compressWriter) close() {
+	if !w.decided && w.status != 0 {
+		_ = w.decide(false)
+	}
+
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+		w.encoder.Reset(nil)
+		w.m.encoders[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
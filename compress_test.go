@@ -0,0 +1,260 @@
+package tea
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressionMiddleware(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Repeat(`{"message": "hello"}`, 100)
+	serve := func(m CompressionMiddleware, acceptEncoding string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		m.Handle(handler).ServeHTTP(w, r)
+		return w
+	}
+
+	decode := func(t *testing.T, encoding string, b []byte) string {
+		var r io.Reader
+		switch encoding {
+		case "zstd":
+			d, err := zstd.NewReader(bytes.NewReader(b))
+			assert.Nil(t, err)
+			defer d.Close()
+			r = d
+		case "br":
+			r = brotli.NewReader(bytes.NewReader(b))
+		case "gzip":
+			d, err := gzip.NewReader(bytes.NewReader(b))
+			assert.Nil(t, err)
+			r = d
+		case "deflate":
+			r = flate.NewReader(bytes.NewReader(b))
+		default:
+			r = bytes.NewReader(b)
+		}
+
+		decoded, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		return string(decoded)
+	}
+
+	jsonHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "2000")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(body[:1000]))
+		_, _ = w.Write([]byte(body[1000:]))
+	}
+
+	t.Run("negotiates encoding", func(t *testing.T) {
+		m := NewCompressionMiddleware(CompressionOptions{})
+		for acceptEncoding, expected := range map[string]string{
+			"":                           "",
+			"identity":                   "",
+			"gzip":                       "gzip",
+			"GZIP, deflate":              "gzip",
+			"gzip, deflate, br, zstd":    "zstd",
+			"gzip;q=1.0, br;q=0.5":       "gzip",
+			"zstd;q=0, br":               "br",
+			"*":                          "zstd",
+			"*;q=0.1, deflate":           "deflate",
+			"compress":                   "",
+			"gzip;q=bad, deflate;q=0.99": "gzip",
+		} {
+			assert.Equal(t, expected, m.negotiate(acceptEncoding), acceptEncoding)
+		}
+
+		m = NewCompressionMiddleware(CompressionOptions{Encodings: []string{"gzip", "compress"}})
+		assert.Equal(t, "gzip", m.negotiate("*"))
+		w := serve(m, "compress", jsonHandler)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("compresses", func(t *testing.T) {
+		m := NewCompressionMiddleware(CompressionOptions{})
+		for _, encoding := range []string{"zstd", "br", "gzip", "deflate"} {
+			for i := 0; i < 2; i++ {
+				w := serve(m, encoding, jsonHandler)
+				assert.Equal(t, http.StatusCreated, w.Code)
+				assert.Equal(t, encoding, w.Header().Get("Content-Encoding"))
+				assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+				assert.Empty(t, w.Header().Get("Content-Length"))
+				assert.Less(t, w.Body.Len(), len(body))
+				assert.Equal(t, body, decode(t, encoding, w.Body.Bytes()), encoding)
+			}
+		}
+	})
+
+	t.Run("below threshold", func(t *testing.T) {
+		m := NewCompressionMiddleware(CompressionOptions{})
+		w := serve(m, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{}`))
+		})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, `{}`, w.Body.String())
+	})
+
+	t.Run("not compressible", func(t *testing.T) {
+		m := NewCompressionMiddleware(CompressionOptions{MinSize: 1})
+		w := serve(m, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte(body))
+		})
+
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Empty(t, w.Header().Get("Vary"))
+		assert.Equal(t, body, w.Body.String())
+
+		w = serve(m, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = w.Write([]byte(body))
+		})
+
+		assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, body, w.Body.String())
+
+		w = serve(m, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Range", "bytes 0-1/2")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write([]byte("ok"))
+		})
+
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "ok", w.Body.String())
+
+		w = serve(m, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", ";bad")
+			_, _ = w.Write([]byte(body))
+		})
+
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		m := NewCompressionMiddleware(CompressionOptions{MinSize: 1})
+		w := serve(m, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Empty(t, w.Body.Bytes())
+
+		w = serve(m, "gzip", func(w http.ResponseWriter, r *http.Request) {})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("existing vary, etag and sniffing", func(t *testing.T) {
+		m := NewCompressionMiddleware(CompressionOptions{MinSize: 1})
+		w := serve(m, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Vary", "Origin, accept-encoding")
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write([]byte("<html><body>hello</body></html>"))
+		})
+
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, []string{"Origin, accept-encoding"}, w.Header().Values("Vary"))
+		assert.Equal(t, `W/"v1"`, w.Header().Get("ETag"))
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	})
+
+	t.Run("head and upgrade", func(t *testing.T) {
+		m := NewCompressionMiddleware(CompressionOptions{MinSize: 1})
+		for _, r := range []*http.Request{httptest.NewRequest("HEAD", "/test", nil), httptest.NewRequest("GET", "/test", nil)} {
+			r.Header.Set("Accept-Encoding", "gzip")
+			if r.Method == "GET" {
+				r.Header.Set("Upgrade", "websocket")
+			}
+
+			w := httptest.NewRecorder()
+			m.Handle(http.HandlerFunc(jsonHandler)).ServeHTTP(w, r)
+			assert.Empty(t, w.Header().Get("Content-Encoding"))
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		m := NewCompressionMiddleware(CompressionOptions{})
+		w := serve(m, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+
+			flushed := w.(interface{ Unwrap() http.ResponseWriter }).Unwrap().(*httptest.ResponseRecorder)
+			assert.True(t, flushed.Flushed)
+			assert.Equal(t, "gzip", flushed.Header().Get("Content-Encoding"))
+			d, err := gzip.NewReader(bytes.NewReader(flushed.Body.Bytes()))
+			assert.Nil(t, err)
+			b := make([]byte, 9)
+			_, err = io.ReadFull(d, b)
+			assert.Nil(t, err)
+			assert.Equal(t, "data: 1\n\n", string(b))
+
+			_, _ = w.Write([]byte("data: 2\n\n"))
+			w.(http.Flusher).Flush()
+		})
+
+		assert.Equal(t, "data: 1\n\ndata: 2\n\n", decode(t, "gzip", w.Body.Bytes()))
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		serve(NewCompressionMiddleware(CompressionOptions{}), "gzip", func(w http.ResponseWriter, r *http.Request) {
+			_, _, err := w.(http.Hijacker).Hijack()
+			assert.Equal(t, http.ErrNotSupported, err)
+			assert.Equal(t, http.ErrNotSupported, w.(http.Pusher).Push("/app.js", nil))
+		})
+	})
+
+	t.Run("router", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Use(NewCompressionMiddleware(CompressionOptions{}), PreRouting())
+		r.Route("GET", "/test", jsonHandler)
+
+		req := httptest.NewRequest("GET", "/v0/test", nil)
+		req.Header.Set("Accept-Encoding", "br")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+		assert.NotEmpty(t, w.Header().Get("Request-Id"))
+		assert.Equal(t, body, decode(t, "br", w.Body.Bytes()))
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(jsonHandler))
+		defer s.Close()
+
+		p := NewProxy("")
+		p.Middleware(NewCompressionMiddleware(CompressionOptions{}))
+		assert.Nil(t, p.Direct("test", s.URL))
+
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.Header.Set("Accept-Encoding", "zstd")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, "zstd", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, body, decode(t, "zstd", w.Body.Bytes()))
+	})
+}
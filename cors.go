@@ -0,0 +1,168 @@
+package tea
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/cors"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// CORSOptions is a cross-origin resource sharing policy
+type CORSOptions struct {
+	// AllowedOrigins are the origins allowed to make cross-origin requests (all if empty)
+	// origins may contain a wildcard subdomain (e.g., https://*.pghq.app)
+	AllowedOrigins []string
+
+	// AllowedOriginPatterns are regular expressions matching additional allowed origins
+	AllowedOriginPatterns []*regexp.Regexp
+
+	// AllowedMethods are the methods allowed for cross-origin requests (common methods if empty)
+	AllowedMethods []string
+
+	// AllowedHeaders are the request headers allowed for cross-origin requests (all if empty)
+	AllowedHeaders []string
+
+	// ExposedHeaders are the response headers exposed to clients (Request-Id is always exposed)
+	ExposedHeaders []string
+
+	// MaxAge is how long clients may cache preflight responses
+	MaxAge time.Duration
+
+	// AllowCredentials allows cookies and authorization headers to be sent with requests
+	// credentialed requests are only allowed from origins that are explicitly allowed (never all origins or *)
+	AllowCredentials bool
+}
+
+// allowsOrigin checks whether the origin is allowed by the policy
+// any origin is only allowed without credentials, as it would let any site make credentialed requests
+func (o CORSOptions) allowsOrigin(origin string) bool {
+	if len(o.AllowedOrigins) == 0 && len(o.AllowedOriginPatterns) == 0 {
+		return !o.AllowCredentials
+	}
+
+	origin = strings.ToLower(origin)
+	for _, allowed := range o.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" {
+			if !o.AllowCredentials {
+				return true
+			}
+
+			continue
+		}
+
+		if allowed == origin {
+			return true
+		}
+
+		if i := strings.Index(allowed, "*"); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+
+	for _, pattern := range o.AllowedOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// explicit checks whether the policy allows specific origins (rather than all origins)
+func (o CORSOptions) explicit() bool {
+	for _, allowed := range o.AllowedOrigins {
+		if allowed != "*" {
+			return true
+		}
+	}
+
+	return len(o.AllowedOriginPatterns) > 0
+}
+
+// CORSMiddleware is an implementation of the CORS middleware
+// providing method, origin, and credential allowance
+type CORSMiddleware struct{ cors *cors.Cors }
+
+// Handle provides an http handler for handling CORS
+func (m CORSMiddleware) Handle(next http.Handler) http.Handler {
+	return m.cors.Handler(next)
+}
+
+// NewCORSMiddleware constructs a new middleware that handles CORS
+// it may be passed to Router.Route to override the router policy for a route
+func NewCORSMiddleware(opts CORSOptions) CORSMiddleware {
+	methods := opts.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{
+			http.MethodOptions,
+			http.MethodHead,
+			http.MethodGet,
+			http.MethodPost,
+			http.MethodDelete,
+			http.MethodPatch,
+			http.MethodPut,
+		}
+	}
+
+	if opts.AllowCredentials && !opts.explicit() {
+		trail.Warn("tea.cors: credentials are only allowed from explicitly allowed origins, no origins are allowed")
+	}
+
+	headers := opts.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"*"}
+	}
+
+	return CORSMiddleware{
+		cors: cors.New(cors.Options{
+			AllowOriginFunc:  opts.allowsOrigin,
+			AllowCredentials: opts.AllowCredentials,
+			AllowedMethods:   methods,
+			AllowedHeaders:   headers,
+			ExposedHeaders:   append([]string{"Request-Id"}, opts.ExposedHeaders...),
+			MaxAge:           int(opts.MaxAge.Seconds()),
+		}),
+	}
+}
+
+// WithCORS creates a router-wide CORS policy option
+func WithCORS(opts CORSOptions) RouterOption {
+	return func(r *Router) {
+		m := NewCORSMiddleware(opts)
+		r.cors = &m
+	}
+}
+
+// handleCORS provides a http handler applying the CORS policy of the route (or router)
+// CORS preflight requests are answered before the router handles OPTIONS requests.
+func (r *Router) handleCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		policy := r.cors
+		if len(r.corsRoutes) > 0 {
+			match := req
+			if method := req.Header.Get("Access-Control-Request-Method"); req.Method == http.MethodOptions && method != "" {
+				match = req.Clone(req.Context())
+				match.Method = method
+			}
+
+			if m, present := r.corsRoutes[r.template(match)]; present {
+				policy = &m
+			}
+		}
+
+		if policy == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		policy.Handle(next).ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,443 @@
+package tea
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// director forwards requests to a pool of upstreams
+type director struct {
+	prefix      string
+	stripPrefix bool
+	addPrefix   string
+	rewrites    []pathRewrite
+	hostHeader  string
+	resolver    *trail.IPResolver
+	pool        *pool
+	proxy       *httputil.ReverseProxy
+	timeout     time.Duration
+	retry       *RetryOptions
+	budget      *retryBudget
+	breaker     *CircuitBreakerOptions
+	match       directorMatch
+	splits      []*split
+	sticky      HashKey
+	override    string
+	shadow      *MirrorOptions
+	flush       time.Duration
+}
+
+func (d *director) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.proxy.ServeHTTP(w, r)
+}
+
+// direct prepares the outgoing request for the upstream
+func (d *director) direct(r *http.Request) {
+	d.forward(r)
+
+	urlPath := r.URL.Path
+	if prefix := "/" + strings.Trim(d.prefix, "/"); d.stripPrefix && strings.HasPrefix(urlPath, prefix) {
+		urlPath = "/" + strings.TrimPrefix(strings.TrimPrefix(urlPath, prefix), "/")
+		r.Header.Set("X-Forwarded-Prefix", strings.TrimSuffix(r.Header.Get("X-Forwarded-Prefix"), "/")+prefix)
+	}
+
+	for _, rewrite := range d.rewrites {
+		urlPath = rewrite.pattern.ReplaceAllString(urlPath, rewrite.replacement)
+	}
+
+	if d.addPrefix != "" {
+		urlPath = path.Join("/", d.addPrefix, urlPath)
+		if strings.HasSuffix(r.URL.Path, "/") && !strings.HasSuffix(urlPath, "/") {
+			urlPath += "/"
+		}
+	}
+
+	if urlPath != r.URL.Path {
+		r.URL.Path = urlPath
+		r.URL.RawPath = ""
+	}
+
+	if d.hostHeader != "" {
+		r.Host = d.hostHeader
+	}
+}
+
+// forward sets the Forwarded (RFC 7239) and X-Forwarded-* headers for the outgoing request
+// forwarding headers sent by the client are only honored if it is a trusted proxy
+// (X-Forwarded-For is appended to by httputil.ReverseProxy after the request is directed)
+func (d *director) forward(r *http.Request) {
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if !d.resolver.Trusted(net.ParseIP(ip)) {
+		for _, header := range []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "X-Forwarded-Prefix", "X-Real-Ip"} {
+			r.Header.Del(header)
+		}
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	element := fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedNode(ip), quote(r.Host), proto)
+	if prior := r.Header.Get("Forwarded"); prior != "" {
+		element = prior + ", " + element
+	}
+
+	r.Header.Set("Forwarded", element)
+	if r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+
+	if r.Header.Get("X-Forwarded-Proto") == "" {
+		r.Header.Set("X-Forwarded-Proto", proto)
+	}
+}
+
+// forwardedNode formats an ip as a Forwarded header node (RFC 7239 section 6)
+func forwardedNode(ip string) string {
+	if ip == "" {
+		return "unknown"
+	}
+
+	if strings.Contains(ip, ":") {
+		return fmt.Sprintf(`"[%s]"`, ip)
+	}
+
+	return ip
+}
+
+// quote formats a value as a Forwarded header quoted-string if necessary
+func quote(value string) string {
+	if strings.ContainsAny(value, `:[]";, `) {
+		return strconv.Quote(value)
+	}
+
+	return value
+}
+
+// pathRewrite is a regular expression rewrite of the request path
+type pathRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// RoundTrip sends the request to an upstream selected from the pool
+// retrying idempotent requests according to the director's retry policy
+func (d *director) RoundTrip(r *http.Request) (*http.Response, error) {
+	pool := d.pool
+	if len(d.splits) > 0 || d.override != "" {
+		var version string
+		version, pool = d.version(r)
+		span := trail.StartSpan(r.Context(), "Proxy.Split")
+		span.Tags.Set("Version", version)
+		span.Finish()
+	}
+
+	attempts := 1
+	if d.retry != nil && idempotent(r.Method) && replayable(r, d.retry.MaxBodySize) {
+		attempts = d.retry.Attempts
+		d.budget.request()
+	}
+
+	mirrored := d.mirrored() && r.Header.Get("Upgrade") == "" && replayable(r, d.shadow.MaxBodySize)
+	if (attempts > 1 || mirrored) && r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, trail.ErrorBadRequest(err)
+		}
+
+		_ = r.Body.Close()
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+
+		r.Body, _ = r.GetBody()
+	}
+
+	if mirrored {
+		d.mirror(r)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := d.attempt(r, pool)
+		if attempt >= attempts || !retryable(resp, err) || r.Context().Err() != nil || !d.budget.withdraw() {
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(d.retry.delay(attempt)):
+		case <-r.Context().Done():
+			return nil, trail.Stacktrace(r.Context().Err())
+		}
+
+		if r.GetBody != nil {
+			if r.Body, err = r.GetBody(); err != nil {
+				return nil, trail.Stacktrace(err)
+			}
+		}
+	}
+}
+
+// replayable checks whether the request body can be sent again (e.g., for retries or mirroring)
+// without buffering more than the limit in memory
+func replayable(r *http.Request, limit int64) bool {
+	if r.Body == nil || r.Body == http.NoBody || r.GetBody != nil {
+		return true
+	}
+
+	return r.ContentLength >= 0 && r.ContentLength <= limit
+}
+
+// attempt sends the request to an upstream selected from the pool
+func (d *director) attempt(r *http.Request, pool *pool) (*http.Response, error) {
+	upstream := pool.next(r)
+	if upstream == nil {
+		return nil, trail.NewErrorServiceUnavailable("no healthy upstream")
+	}
+
+	if upstream.breaker != nil && !upstream.breaker.acquire() {
+		return nil, trail.NewErrorServiceUnavailable("circuit open")
+	}
+
+	parent := r.Context()
+	ctx, cancel := context.WithCancel(parent)
+	stop := func() bool { return true }
+	if d.timeout > 0 {
+		stop = time.AfterFunc(d.timeout, cancel).Stop
+	}
+
+	r = r.WithContext(ctx)
+	r.URL.Scheme = upstream.URL.Scheme
+	r.URL.Host = upstream.URL.Host
+	upstream.acquire()
+	resp, err := http.DefaultTransport.RoundTrip(r)
+	timedOut := !stop()
+	if timedOut && err == nil {
+		_ = resp.Body.Close()
+		err = context.DeadlineExceeded
+	}
+
+	if err != nil {
+		cancel()
+		upstream.release()
+		if upstream.breaker != nil {
+			// requests cancelled by the client say nothing about the upstream
+			if parent.Err() != nil {
+				upstream.breaker.abort()
+			} else {
+				upstream.breaker.failure()
+			}
+		}
+
+		if timedOut {
+			return nil, trail.NewErrorWithCode("upstream timeout", http.StatusGatewayTimeout)
+		}
+
+		return nil, trail.ErrorBadGateway(err)
+	}
+
+	if upstream.breaker != nil {
+		if upstream.breaker.opts.fails(resp.StatusCode) {
+			upstream.breaker.failure()
+		} else {
+			upstream.breaker.success()
+		}
+	}
+
+	body := releaseBody{ReadCloser: resp.Body, release: func() {
+		cancel()
+		upstream.release()
+	}}
+
+	resp.Body = &body
+	if rw, ok := body.ReadCloser.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.Body = &releaseReadWriteBody{releaseBody: &body, Writer: rw}
+	}
+
+	return resp, nil
+}
+
+// releaseBody releases the upstream connection once the response body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// releaseReadWriteBody is a writable response body for upgraded connections (e.g., WebSockets)
+type releaseReadWriteBody struct {
+	*releaseBody
+	io.Writer
+}
+
+// DirectorOption is a handler for configuring a director
+type DirectorOption func(d *director) error
+
+// WithUpstreams creates an option adding hosts to the director's upstream pool
+func WithUpstreams(hosts ...string) DirectorOption {
+	return func(d *director) error {
+		for _, host := range hosts {
+			if err := WithWeightedUpstream(host, 1)(d); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// WithWeightedUpstream creates an option adding a weighted host to the director's upstream pool
+func WithWeightedUpstream(host string, weight int) DirectorOption {
+	return func(d *director) error {
+		upstream, err := newUpstream(host, weight)
+		if err != nil {
+			return err
+		}
+
+		d.pool.upstreams = append(d.pool.upstreams, upstream)
+		return nil
+	}
+}
+
+// WithBalancer creates an option for the strategy balancing requests across the upstream pool
+// (defaults to RoundRobin)
+func WithBalancer(b Balancer) DirectorOption {
+	return func(d *director) error {
+		d.pool.balancer = b
+		return nil
+	}
+}
+
+// WithHealthCheck creates an option for ejecting upstreams which fail their health checks
+// upstream health status endpoints are checked at most once per interval
+// and upstreams not responding within the interval (at most 5s) are ejected
+func WithHealthCheck(interval time.Duration) DirectorOption {
+	return func(d *director) error {
+		d.pool.healthCheck = interval
+		return nil
+	}
+}
+
+// WithTimeout creates an option limiting how long each attempt waits for upstream response headers
+// (streamed response bodies and upgraded connections are not limited)
+func WithTimeout(timeout time.Duration) DirectorOption {
+	return func(d *director) error {
+		d.timeout = timeout
+		return nil
+	}
+}
+
+// WithRetries creates an option for retrying failed idempotent requests
+func WithRetries(opts RetryOptions) DirectorOption {
+	return func(d *director) error {
+		if opts.Attempts < 1 {
+			opts.Attempts = 3
+		}
+
+		if opts.Backoff <= 0 {
+			opts.Backoff = 50 * time.Millisecond
+		}
+
+		if opts.MaxBackoff <= 0 {
+			opts.MaxBackoff = time.Second
+		}
+
+		if opts.Budget <= 0 {
+			opts.Budget = 0.2
+		}
+
+		if opts.MinRetries <= 0 {
+			opts.MinRetries = 10
+		}
+
+		if opts.MaxBodySize <= 0 {
+			opts.MaxBodySize = maxReplaySize
+		}
+
+		d.retry = &opts
+		d.budget = newRetryBudget(opts)
+		return nil
+	}
+}
+
+// WithCircuitBreaker creates an option for ejecting upstreams after consecutive failures
+// the state of each upstream circuit is reported by the proxy health status
+func WithCircuitBreaker(opts CircuitBreakerOptions) DirectorOption {
+	return func(d *director) error {
+		d.breaker = &opts
+		return nil
+	}
+}
+
+// WithStripPrefix creates an option for removing the director's path prefix before forwarding
+// (e.g., /users/1 is forwarded as /1 with an X-Forwarded-Prefix of /users)
+func WithStripPrefix() DirectorOption {
+	return func(d *director) error {
+		d.stripPrefix = true
+		return nil
+	}
+}
+
+// WithAddPrefix creates an option for adding a path prefix before forwarding
+// (applied after stripping and rewriting, e.g., /v1/users/1 becomes /api/v1/users/1)
+func WithAddPrefix(prefix string) DirectorOption {
+	return func(d *director) error {
+		d.addPrefix = prefix
+		return nil
+	}
+}
+
+// WithPathRewrite creates an option for rewriting the path with a regular expression before forwarding
+// (applied after stripping, replacement may reference capture groups, e.g., $1)
+func WithPathRewrite(pattern, replacement string) DirectorOption {
+	return func(d *director) error {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+
+		d.rewrites = append(d.rewrites, pathRewrite{pattern: re, replacement: replacement})
+		return nil
+	}
+}
+
+// WithHostHeader creates an option for overriding the Host header sent to upstreams
+func WithHostHeader(host string) DirectorOption {
+	return func(d *director) error {
+		d.hostHeader = host
+		return nil
+	}
+}
+
+// WithFlushInterval creates an option for how often streamed responses are flushed to the client
+// (a negative interval flushes after each write, server-sent events are always flushed immediately)
+func WithFlushInterval(interval time.Duration) DirectorOption {
+	return func(d *director) error {
+		d.flush = interval
+		return nil
+	}
+}
@@ -0,0 +1,110 @@
+package tea
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pghq/go-tea/trail"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirector_Direct(t *testing.T) {
+	t.Parallel()
+
+	direct := func(t *testing.T, target string, opts ...DirectorOption) *http.Request {
+		d := director{prefix: "users"}
+		for _, opt := range opts {
+			assert.Nil(t, opt(&d))
+		}
+
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("X-Forwarded-Prefix", "/spoofed")
+		d.direct(r)
+		return r
+	}
+
+	t.Run("forwards full path", func(t *testing.T) {
+		r := direct(t, "/users/1")
+		assert.Equal(t, "/users/1", r.URL.Path)
+		assert.Equal(t, "example.com", r.Host)
+		assert.Empty(t, r.Header.Get("X-Forwarded-Prefix"))
+	})
+
+	t.Run("strip prefix", func(t *testing.T) {
+		r := direct(t, "/users/1?q=test", WithStripPrefix())
+		assert.Equal(t, "/1", r.URL.Path)
+		assert.Equal(t, "q=test", r.URL.RawQuery)
+		assert.Equal(t, "/users", r.Header.Get("X-Forwarded-Prefix"))
+
+		r = direct(t, "/users", WithStripPrefix())
+		assert.Equal(t, "/", r.URL.Path)
+	})
+
+	t.Run("add prefix", func(t *testing.T) {
+		r := direct(t, "/users/1/", WithStripPrefix(), WithAddPrefix("/api"))
+		assert.Equal(t, "/api/1/", r.URL.Path)
+	})
+
+	t.Run("regex rewrite", func(t *testing.T) {
+		r := direct(t, "/users/1/avatar", WithPathRewrite(`^/users/(\d+)/avatar$`, "/avatars/$1"))
+		assert.Equal(t, "/avatars/1", r.URL.Path)
+
+		d := director{}
+		assert.NotNil(t, WithPathRewrite(`(`, "")(&d))
+	})
+
+	t.Run("host header", func(t *testing.T) {
+		r := direct(t, "/users/1", WithHostHeader("users.internal"))
+		assert.Equal(t, "users.internal", r.Host)
+	})
+}
+
+func TestDirector_Forward(t *testing.T) {
+	t.Parallel()
+
+	spoofed := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest("GET", "/users/1", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Forwarded", "for=1.2.3.4")
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+		r.Header.Set("X-Forwarded-Host", "spoofed.tld")
+		r.Header.Set("X-Forwarded-Proto", "https")
+		return r
+	}
+
+	t.Run("strips untrusted headers", func(t *testing.T) {
+		d := director{resolver: trail.NewIPResolver(trail.WithTrustedProxies("10.0.0.0/8"))}
+		r := spoofed("192.0.2.1:1234")
+		d.forward(r)
+		assert.Equal(t, "for=192.0.2.1;host=example.com;proto=http", r.Header.Get("Forwarded"))
+		assert.Empty(t, r.Header.Get("X-Forwarded-For"))
+		assert.Equal(t, "example.com", r.Header.Get("X-Forwarded-Host"))
+		assert.Equal(t, "http", r.Header.Get("X-Forwarded-Proto"))
+	})
+
+	t.Run("honors trusted headers", func(t *testing.T) {
+		d := director{resolver: trail.NewIPResolver(trail.WithTrustedProxies("10.0.0.0/8", "2001:db8::1"))}
+		r := spoofed("10.0.0.1:1234")
+		d.forward(r)
+		assert.Equal(t, "for=1.2.3.4, for=10.0.0.1;host=example.com;proto=http", r.Header.Get("Forwarded"))
+		assert.Equal(t, "1.2.3.4", r.Header.Get("X-Forwarded-For"))
+		assert.Equal(t, "spoofed.tld", r.Header.Get("X-Forwarded-Host"))
+		assert.Equal(t, "https", r.Header.Get("X-Forwarded-Proto"))
+
+		r = spoofed("[2001:db8::1]:1234")
+		r.Host = "example.com:8080"
+		r.Header.Del("Forwarded")
+		d.forward(r)
+		assert.Equal(t, `for="[2001:db8::1]";host="example.com:8080";proto=http`, r.Header.Get("Forwarded"))
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		d := director{}
+		r := httptest.NewRequest("GET", "https://example.com/users/1", nil)
+		d.forward(r)
+		assert.Equal(t, "https", r.Header.Get("X-Forwarded-Proto"))
+		assert.Contains(t, r.Header.Get("Forwarded"), "proto=https")
+	})
+}
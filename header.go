@@ -0,0 +1,209 @@
+package tea
+
+import (
+	"encoding"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// timeType is the type of HTTP-date header fields
+	timeType = reflect.TypeOf(time.Time{})
+
+	// durationType is the type of header fields in seconds
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	// textUnmarshalerType is the type of header fields decoding themselves
+	textUnmarshalerType = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
+
+	// textMarshalerType is the type of header fields encoding themselves
+	textMarshalerType = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
+
+	// cookieType is the type of cookie fields
+	cookieType = reflect.TypeOf(new(http.Cookie))
+)
+
+// parseHeader parses a header value into a value of the type
+// times are HTTP-dates and durations are in seconds or Go duration strings (e.g., 1m30s)
+func parseHeader(value string, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	switch {
+	case t.Kind() == reflect.Ptr:
+		elem, err := parseHeader(value, t.Elem())
+		if err != nil {
+			return v, err
+		}
+
+		v.Set(reflect.New(t.Elem()))
+		v.Elem().Set(elem)
+	case t == timeType:
+		tm, err := http.ParseTime(value)
+		if err != nil {
+			return v, err
+		}
+
+		v.Set(reflect.ValueOf(tm))
+	case t == durationType:
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			v.SetInt(int64(time.Duration(seconds) * time.Second))
+			break
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return v, err
+		}
+
+		v.SetInt(int64(d))
+	case reflect.PtrTo(t).Implements(textUnmarshalerType):
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
+			return v, err
+		}
+	default:
+		switch t.Kind() {
+		case reflect.String:
+			v.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return v, err
+			}
+
+			v.SetBool(b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i, err := strconv.ParseInt(value, 10, t.Bits())
+			if err != nil {
+				return v, err
+			}
+
+			v.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			i, err := strconv.ParseUint(value, 10, t.Bits())
+			if err != nil {
+				return v, err
+			}
+
+			v.SetUint(i)
+		case reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(value, t.Bits())
+			if err != nil {
+				return v, err
+			}
+
+			v.SetFloat(f)
+		default:
+			return v, fmt.Errorf("unsupported type %s", t)
+		}
+	}
+
+	return v, nil
+}
+
+// formatHeader formats a value as a header value
+// times are HTTP-dates and durations are in whole seconds
+func formatHeader(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Type() == timeType:
+		return v.Interface().(time.Time).UTC().Format(http.TimeFormat)
+	case v.Type() == durationType:
+		return strconv.FormatInt(int64(v.Interface().(time.Duration)/time.Second), 10)
+	case v.Type().Implements(textMarshalerType):
+		text, _ := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits())
+	}
+
+	return fmt.Sprintf("%s", v.Interface())
+}
+
+// newCookie creates a cookie from a value and cookie tag
+// the tag options apply to cookie values unless they already set the attribute
+// e.g., `cookie:"session,path=/,domain=pghq.app,maxage=3600,samesite=lax,secure,httponly,omitempty"`
+func newCookie(v reflect.Value, tag string) (*http.Cookie, error) {
+	options := strings.Split(tag, ",")
+	cookie := &http.Cookie{Name: options[0]}
+	switch {
+	case v.Type() == cookieType && !v.IsNil():
+		c := *v.Interface().(*http.Cookie)
+		cookie = &c
+	case v.Type() == cookieType.Elem():
+		c := v.Interface().(http.Cookie)
+		cookie = &c
+	default:
+		cookie.Value = formatHeader(v)
+	}
+
+	if cookie.Name == "" {
+		cookie.Name = options[0]
+	}
+
+	for _, option := range options[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch strings.ToLower(key) {
+		case "path":
+			if cookie.Path == "" {
+				cookie.Path = value
+			}
+		case "domain":
+			if cookie.Domain == "" {
+				cookie.Domain = value
+			}
+		case "maxage":
+			maxAge, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("bad cookie %s max age: %w", cookie.Name, err)
+			}
+
+			if cookie.MaxAge == 0 {
+				cookie.MaxAge = maxAge
+			}
+		case "samesite":
+			sameSite, present := map[string]http.SameSite{
+				"lax":    http.SameSiteLaxMode,
+				"strict": http.SameSiteStrictMode,
+				"none":   http.SameSiteNoneMode,
+			}[strings.ToLower(value)]
+
+			if !present {
+				return nil, fmt.Errorf("bad cookie %s same site %s", cookie.Name, value)
+			}
+
+			if cookie.SameSite == 0 {
+				cookie.SameSite = sameSite
+			}
+		case "secure":
+			cookie.Secure = true
+		case "httponly":
+			cookie.HttpOnly = true
+		case "omitempty":
+		default:
+			return nil, fmt.Errorf("bad cookie %s option %s", cookie.Name, key)
+		}
+	}
+
+	return cookie, nil
+}
@@ -1,6 +1,9 @@
 package health
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 const (
 	// UptimeCheckKey is the key for the uptime health measurement
@@ -29,7 +32,12 @@ func (s *StatusResponse) WithCheck(key string, check *Check) *StatusResponse {
 
 // Status is an API endpoint that presents the health of the current application
 // https://tools.ietf.org/id/draft-inadarei-api-health-check-05.html
-func (s Service) Status() *StatusResponse {
+func (s *Service) Status() *StatusResponse {
+	s.mutex.RLock()
+	dependencies := s.dependencies
+	checks := s.checks
+	s.mutex.RUnlock()
+
 	status := StatusResponse{
 		Version: s.version,
 		Checks:  make(map[string][]*Check),
@@ -38,27 +46,78 @@ func (s Service) Status() *StatusResponse {
 
 	status.WithCheck(UptimeCheckKey, s.Uptime())
 	wg := sync.WaitGroup{}
-	for _, dep := range s.dependencies {
+	for _, dep := range dependencies {
 		wg.Add(1)
 		go func(dep dependency) {
 			defer wg.Done()
 			check := NewDependencyCheck(s.now(), dep.url)
+			status.WithCheck(dep.name, check)
 			if check.Status != StatusHealthy {
+				status.mutex.Lock()
 				status.Status = StatusHealthyWithConcerns
+				status.mutex.Unlock()
 			}
-
-			status.WithCheck(dep.name, check)
 		}(dep)
 	}
 
 	wg.Wait()
 
+	for _, c := range checks {
+		check := c.fn(s.now())
+		if check.Status != StatusHealthy {
+			status.Status = StatusHealthyWithConcerns
+		}
+
+		status.WithCheck(c.name, check)
+	}
+
 	return &status
 }
 
+// AddDependency adds a dependency checked using its health status endpoint
 func (s *Service) AddDependency(dependencyName string, dependencyURL string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.dependencies = append(s.dependencies, dependency{
 		name: dependencyName,
 		url:  dependencyURL,
 	})
 }
+
+// RemoveDependency removes the dependencies with the name
+func (s *Service) RemoveDependency(dependencyName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var dependencies []dependency
+	for _, dep := range s.dependencies {
+		if dep.name != dependencyName {
+			dependencies = append(dependencies, dep)
+		}
+	}
+
+	s.dependencies = dependencies
+}
+
+// AddCheck adds a custom check (e.g., for the state of an application component)
+func (s *Service) AddCheck(checkName string, checkFunc func(observedAt time.Time) *Check) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.checks = append(s.checks, check{
+		name: checkName,
+		fn:   checkFunc,
+	})
+}
+
+// RemoveCheck removes the custom checks with the name
+func (s *Service) RemoveCheck(checkName string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var checks []check
+	for _, c := range s.checks {
+		if c.name != checkName {
+			checks = append(checks, c)
+		}
+	}
+
+	s.checks = checks
+}
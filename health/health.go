@@ -1,6 +1,7 @@
 package health
 
 import (
+	"sync"
 	"time"
 )
 
@@ -10,6 +11,8 @@ type Service struct {
 	start        time.Time
 	version      string
 	dependencies []dependency
+	checks       []check
+	mutex        sync.RWMutex
 }
 
 type dependency struct {
@@ -17,6 +20,11 @@ type dependency struct {
 	url  string
 }
 
+type check struct {
+	name string
+	fn   func(time.Time) *Check
+}
+
 // NewService creates a new health client instance
 func NewService(version string) *Service {
 	return &Service{
@@ -142,3 +142,44 @@ func TestService_Status(t *testing.T) {
 		}, resp.Checks)
 	})
 }
+
+func TestService_AddCheck(t *testing.T) {
+	t.Run("reports custom checks", func(t *testing.T) {
+		now := time.Now()
+		s := NewService("0.0.1")
+		s.now = func() time.Time { return now }
+		s.AddCheck("component", func(observedAt time.Time) *Check {
+			return NewHealthyCheck(observedAt, "ok", "state")
+		})
+
+		resp := s.Status()
+		assert.Equal(t, StatusHealthy, resp.Status)
+		assert.Equal(t, []*Check{NewHealthyCheck(now, "ok", "state")}, resp.Checks["component"])
+
+		s.AddCheck("failing", func(observedAt time.Time) *Check {
+			c := NewHealthyCheck(observedAt, "open", "state")
+			c.Status = StatusUnhealthy
+			return c
+		})
+
+		assert.Equal(t, StatusHealthyWithConcerns, s.Status().Status)
+	})
+}
+
+func TestService_Remove(t *testing.T) {
+	t.Run("removes dependencies and checks", func(t *testing.T) {
+		s := NewService("0.0.1")
+		s.AddDependency("down", "http://localhost:0/health/status")
+		s.AddCheck("failing", func(observedAt time.Time) *Check {
+			c := NewHealthyCheck(observedAt, "open", "state")
+			c.Status = StatusUnhealthy
+			return c
+		})
+
+		s.RemoveDependency("down")
+		s.RemoveCheck("failing")
+		resp := s.Status()
+		assert.Equal(t, StatusHealthy, resp.Status)
+		assert.Len(t, resp.Checks, 1)
+	})
+}
@@ -0,0 +1,491 @@
+package tea
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// JWTOptions is a policy for verifying bearer tokens
+type JWTOptions struct {
+	// Secret is the key for verifying HS256 tokens (HS256 is not accepted if empty)
+	Secret []byte
+
+	// JWKSFile is the path of a JSON Web Key Set for verifying RS256 and ES256 tokens
+	JWKSFile string
+
+	// JWKSURL is the URL of a JSON Web Key Set for verifying RS256 and ES256 tokens
+	// (e.g., https://pghq.auth0.com/.well-known/jwks.json)
+	JWKSURL string
+
+	// JWKSCacheTTL is how long the key set is cached before it is reloaded (default 1h)
+	// the key set is also reloaded at most once a minute for tokens signed with unknown keys
+	JWKSCacheTTL time.Duration
+
+	// Issuer is the required issuer (iss) of tokens (not checked if empty)
+	Issuer string
+
+	// Audience is the required audience (aud) of tokens (not checked if empty)
+	Audience string
+
+	// Leeway is the allowed clock skew for checking the exp and nbf claims
+	Leeway time.Duration
+
+	// AllowMissingExp accepts tokens without an expiration (exp), which are rejected by default
+	AllowMissingExp bool
+}
+
+// JWTMiddleware is an implementation of the bearer token authentication middleware
+// exposing the verified Claims through the request context
+type JWTMiddleware struct {
+	opts JWTOptions
+	jwks *jwks
+}
+
+// Handle provides an http handler for authenticating requests
+func (m JWTMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := auth(r, "bearer")
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			Send(w, r, trail.NewErrorNotAuthorized("missing bearer token"))
+			return
+		}
+
+		claims, err := m.verify(r.Context(), token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			Send(w, r, err)
+			return
+		}
+
+		if req := trail.RequestFromContext(r.Context()); req != nil {
+			if userId, err := uuid.Parse(claims.Subject()); err == nil {
+				req.SetUserId(userId)
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims)))
+	})
+}
+
+// verify checks the signature and claims of a token
+func (m JWTMiddleware) verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, trail.NewErrorNotAuthorized("malformed token")
+	}
+
+	encoding := base64.RawURLEncoding
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	b, err := encoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(b, &header) != nil {
+		return nil, trail.NewErrorNotAuthorized("malformed token header")
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, trail.NewErrorNotAuthorized("malformed token signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	digest := sha256.Sum256(signed)
+	verified := false
+	switch header.Alg {
+	case "HS256":
+		if len(m.opts.Secret) == 0 {
+			return nil, trail.NewErrorNotAuthorized("token algorithm HS256 not supported")
+		}
+
+		mac := hmac.New(sha256.New, m.opts.Secret)
+		mac.Write(signed)
+		verified = hmac.Equal(signature, mac.Sum(nil))
+	case "RS256", "ES256":
+		if m.jwks == nil {
+			return nil, trail.NewErrorNotAuthorized(fmt.Sprintf("token algorithm %s not supported", header.Alg))
+		}
+
+		keys, err := m.jwks.get(ctx, header.Kid)
+		if err != nil {
+			trail.Errorf("key set could not be loaded: %+v", err)
+			return nil, trail.NewErrorNotAuthorized("token could not be verified")
+		}
+
+		for _, key := range keys {
+			if key.alg != "" && key.alg != header.Alg {
+				continue
+			}
+
+			switch pub := key.key.(type) {
+			case *rsa.PublicKey:
+				verified = header.Alg == "RS256" && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+			case *ecdsa.PublicKey:
+				verified = header.Alg == "ES256" && len(signature) == 64 &&
+					ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:]))
+			}
+
+			if verified {
+				break
+			}
+		}
+	default:
+		return nil, trail.NewErrorNotAuthorized(fmt.Sprintf("token algorithm %s not supported", header.Alg))
+	}
+
+	if !verified {
+		return nil, trail.NewErrorNotAuthorized("bad token signature")
+	}
+
+	var claims Claims
+	b, err = encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, trail.NewErrorNotAuthorized("malformed token claims")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil || claims == nil {
+		return nil, trail.NewErrorNotAuthorized("malformed token claims")
+	}
+
+	return claims, m.validate(claims)
+}
+
+// validate checks the registered claims of a token
+func (m JWTMiddleware) validate(claims Claims) error {
+	now := time.Now()
+	exp, present, err := claims.time("exp")
+	if err != nil {
+		return err
+	}
+
+	if !present && !m.opts.AllowMissingExp {
+		return trail.NewErrorNotAuthorized("token expiration required")
+	}
+
+	if present && !now.Before(exp.Add(m.opts.Leeway)) {
+		return trail.NewErrorNotAuthorized("token expired")
+	}
+
+	nbf, present, err := claims.time("nbf")
+	if err != nil {
+		return err
+	}
+
+	if present && now.Before(nbf.Add(-m.opts.Leeway)) {
+		return trail.NewErrorNotAuthorized("token not valid yet")
+	}
+
+	if m.opts.Issuer != "" && claims["iss"] != m.opts.Issuer {
+		return trail.NewErrorNotAuthorized("bad token issuer")
+	}
+
+	if m.opts.Audience != "" {
+		var audience []string
+		switch aud := claims["aud"].(type) {
+		case string:
+			audience = []string{aud}
+		case []interface{}:
+			for _, v := range aud {
+				if s, ok := v.(string); ok {
+					audience = append(audience, s)
+				}
+			}
+		}
+
+		for _, aud := range audience {
+			if aud == m.opts.Audience {
+				return nil
+			}
+		}
+
+		return trail.NewErrorNotAuthorized("bad token audience")
+	}
+
+	return nil
+}
+
+// NewJWTMiddleware constructs a new middleware that authenticates requests with bearer tokens
+// e.g., r.Use(NewJWTMiddleware(JWTOptions{JWKSURL: "https://pghq.auth0.com/.well-known/jwks.json", Audience: "api"}))
+func NewJWTMiddleware(opts JWTOptions) JWTMiddleware {
+	if opts.JWKSCacheTTL <= 0 {
+		opts.JWKSCacheTTL = time.Hour
+	}
+
+	m := JWTMiddleware{opts: opts}
+	if opts.JWKSFile != "" || opts.JWKSURL != "" {
+		m.jwks = &jwks{
+			file:   opts.JWKSFile,
+			url:    opts.JWKSURL,
+			ttl:    opts.JWKSCacheTTL,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	return m
+}
+
+// Claims are the claims of an authenticated request (e.g., from a verified bearer token)
+// numbers in bearer tokens are json.Number values
+type Claims map[string]interface{}
+
+// Subject gets the subject (sub) of the claims
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Decode decodes the claims into a value
+// json struct tags are supported
+func (c Claims) Decode(v interface{}) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return trail.Stacktrace(err)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return trail.Stacktrace(err)
+	}
+
+	return nil
+}
+
+// time gets a claim in seconds since the epoch (e.g., exp)
+func (c Claims) time(key string) (time.Time, bool, error) {
+	v, present := c[key]
+	if !present {
+		return time.Time{}, false, nil
+	}
+
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, trail.NewErrorNotAuthorized(fmt.Sprintf("bad token claim %s", key))
+	}
+
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, trail.NewErrorNotAuthorized(fmt.Sprintf("bad token claim %s", key))
+	}
+
+	return time.Unix(0, int64(seconds*float64(time.Second))), true, nil
+}
+
+// ClaimsFromContext gets the claims of the authenticated request (if any)
+func ClaimsFromContext(ctx context.Context) Claims {
+	c, _ := ctx.Value(claimsContextKey{}).(Claims)
+	return c
+}
+
+// claimsContextKey is the context key for claims
+type claimsContextKey struct{}
+
+// jwks is a cached JSON Web Key Set
+type jwks struct {
+	mutex   sync.Mutex
+	file    string
+	url     string
+	ttl     time.Duration
+	client  *http.Client
+	keys    []jwk
+	fetched time.Time
+	failed  time.Time
+	err     error
+	loading chan struct{}
+}
+
+// jwk is a public key from a JSON Web Key Set
+type jwk struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// get gets the keys matching the key id (or all keys if empty)
+// the key set is reloaded in the background if it is stale (serving the stale keys meanwhile)
+// and waited for if it was never loaded or the key id is unknown, failures are retried at most once a minute
+func (s *jwks) get(ctx context.Context, kid string) ([]jwk, error) {
+	s.mutex.Lock()
+	keys := s.match(kid)
+	age := time.Since(s.fetched)
+	stale := s.fetched.IsZero() || age > s.ttl || len(keys) == 0 && age > time.Minute
+	if stale && time.Since(s.failed) > time.Minute {
+		done := s.reload()
+		if len(keys) == 0 {
+			s.mutex.Unlock()
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return nil, trail.Stacktrace(ctx.Err())
+			}
+
+			s.mutex.Lock()
+			keys = s.match(kid)
+		}
+	}
+
+	defer s.mutex.Unlock()
+	if s.fetched.IsZero() {
+		if s.err != nil {
+			return nil, s.err
+		}
+
+		return nil, trail.NewError("key set not loaded")
+	}
+
+	return keys, nil
+}
+
+// reload starts loading the key set unless it is already loading (the mutex must be held)
+// the key set is loaded with its own timeout so cancelled requests do not fail the load for the others
+func (s *jwks) reload() <-chan struct{} {
+	if s.loading != nil {
+		return s.loading
+	}
+
+	done := make(chan struct{})
+	s.loading = done
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), s.client.Timeout)
+		defer cancel()
+
+		keys, err := s.load(ctx)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.loading = nil
+		if err != nil {
+			s.failed, s.err = time.Now(), err
+			if !s.fetched.IsZero() {
+				trail.Warnf("stale key set used: %+v", err)
+			}
+
+			return
+		}
+
+		s.keys, s.fetched, s.err = keys, time.Now(), nil
+	}()
+
+	return done
+}
+
+// match gets the cached keys matching the key id
+func (s *jwks) match(kid string) []jwk {
+	var keys []jwk
+	for _, key := range s.keys {
+		if kid == "" || key.kid == kid {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// load reads the key set from the file or URL
+func (s *jwks) load(ctx context.Context) ([]jwk, error) {
+	var b []byte
+	var err error
+	if s.file != "" {
+		b, err = os.ReadFile(s.file)
+		if err != nil {
+			return nil, trail.Stacktrace(err)
+		}
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+		if err != nil {
+			return nil, trail.Stacktrace(err)
+		}
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, trail.Stacktrace(err)
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, trail.NewErrorf("key set request failed with status %d", resp.StatusCode)
+		}
+
+		b, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return nil, trail.Stacktrace(err)
+		}
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, trail.Stacktrace(err)
+	}
+
+	var keys []jwk
+	encoding := base64.RawURLEncoding
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key := jwk{kid: k.Kid, alg: k.Alg}
+		switch {
+		case k.Kty == "RSA":
+			n, nerr := encoding.DecodeString(k.N)
+			e, eerr := encoding.DecodeString(k.E)
+			if nerr != nil || eerr != nil || len(e) == 0 || len(e) > 4 {
+				trail.Warnf("bad RSA key %s in key set", k.Kid)
+				continue
+			}
+
+			key.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, xerr := encoding.DecodeString(k.X)
+			y, yerr := encoding.DecodeString(k.Y)
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if xerr != nil || yerr != nil || !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				trail.Warnf("bad EC key %s in key set", k.Kid)
+				continue
+			}
+
+			key.key = pub
+		default:
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
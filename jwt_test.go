@@ -0,0 +1,362 @@
+package tea
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// signToken creates a token signed with the key for the algorithm
+func signToken(t *testing.T, alg, kid string, key interface{}, claims interface{}) string {
+	encoding := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		assert.Nil(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		assert.Nil(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return signed + "." + encoding.EncodeToString(signature)
+}
+
+// keySet creates a JSON Web Key Set for the public keys
+func keySet(keys map[string]interface{}) []byte {
+	encoding := base64.RawURLEncoding
+	var set []map[string]string
+	for kid, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			set = append(set, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   encoding.EncodeToString(key.N.Bytes()),
+				"e":   encoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		case *ecdsa.PrivateKey:
+			set = append(set, map[string]string{
+				"kty": "EC",
+				"kid": kid,
+				"alg": "ES256",
+				"crv": "P-256",
+				"x":   encoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				"y":   encoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+	}
+
+	set = append(set,
+		map[string]string{"kty": "RSA", "kid": "bad", "n": "!", "e": "AQAB"},
+		map[string]string{"kty": "EC", "kid": "bad", "crv": "P-256", "x": "AQ", "y": "AQ"},
+		map[string]string{"kty": "oct", "kid": "secret", "k": "c2VjcmV0"},
+		map[string]string{"kty": "RSA", "kid": "enc", "use": "enc"},
+	)
+
+	b, _ := json.Marshal(map[string]interface{}{"keys": set})
+	return b
+}
+
+// wait waits for the key set to finish loading (if loading)
+func (s *jwks) wait() {
+	s.mutex.Lock()
+	loading := s.loading
+	s.mutex.Unlock()
+	if loading != nil {
+		<-loading
+	}
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("a secret key")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	userId := uuid.New()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":   userId.String(),
+			"iss":   "https://pghq.app",
+			"aud":   []string{"api", "web"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": "read write",
+		}
+
+		for key, value := range extra {
+			c[key] = value
+		}
+
+		return c
+	}
+
+	serve := func(m JWTMiddleware, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/tests", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		w := httptest.NewRecorder()
+		m.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, userId.String(), ClaimsFromContext(r.Context()).Subject())
+			Send(w, r, nil)
+		})).ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		m := NewJWTMiddleware(JWTOptions{})
+		assert.Equal(t, time.Hour, m.opts.JWKSCacheTTL)
+		assert.Nil(t, m.jwks)
+		assert.Nil(t, ClaimsFromContext(httptest.NewRequest("GET", "/tests", nil).Context()))
+	})
+
+	t.Run("verifies HS256 tokens", func(t *testing.T) {
+		m := NewJWTMiddleware(JWTOptions{Secret: secret, Issuer: "https://pghq.app", Audience: "api"})
+		w := serve(m, signToken(t, "HS256", "", secret, claims(nil)))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = serve(m, signToken(t, "HS256", "", secret, claims(map[string]interface{}{"aud": "api"})))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = serve(m, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("rejects bad tokens", func(t *testing.T) {
+		m := NewJWTMiddleware(JWTOptions{Secret: secret, Issuer: "https://pghq.app", Audience: "api", Leeway: time.Minute})
+		valid := signToken(t, "HS256", "", secret, claims(nil))
+		parts := strings.Split(valid, ".")
+		unexpiring := claims(nil)
+		delete(unexpiring, "exp")
+		for name, token := range map[string]string{
+			"malformed":         "token",
+			"bad header":        "!." + parts[1] + "." + parts[2],
+			"bad header json":   "e30x." + parts[1] + "." + parts[2],
+			"bad signature":     parts[0] + "." + parts[1] + ".!",
+			"wrong signature":   signToken(t, "HS256", "", []byte("another key"), claims(nil)),
+			"tampered":          parts[0] + "." + strings.Split(signToken(t, "HS256", "", secret, claims(map[string]interface{}{"sub": "admin"})), ".")[1] + "." + parts[2],
+			"none":              strings.Split(signToken(t, "none", "", nil, claims(nil)), ".")[0] + "." + parts[1] + ".",
+			"no key set":        signToken(t, "RS256", "", rsaKey, claims(nil)),
+			"expired":           signToken(t, "HS256", "", secret, claims(map[string]interface{}{"exp": time.Now().Add(-2 * time.Minute).Unix()})),
+			"not valid yet":     signToken(t, "HS256", "", secret, claims(map[string]interface{}{"nbf": time.Now().Add(2 * time.Minute).Unix()})),
+			"missing exp":       signToken(t, "HS256", "", secret, unexpiring),
+			"bad exp":           signToken(t, "HS256", "", secret, claims(map[string]interface{}{"exp": "tomorrow"})),
+			"bad nbf":           signToken(t, "HS256", "", secret, claims(map[string]interface{}{"nbf": "today"})),
+			"bad issuer":        signToken(t, "HS256", "", secret, claims(map[string]interface{}{"iss": "https://example.com"})),
+			"bad audience":      signToken(t, "HS256", "", secret, claims(map[string]interface{}{"aud": "web"})),
+			"missing audience":  signToken(t, "HS256", "", secret, claims(map[string]interface{}{"aud": nil})),
+			"malformed claims":  parts[0] + ".!." + parts[2],
+			"non object claims": signToken(t, "HS256", "", secret, nil),
+		} {
+			w := serve(m, token)
+			assert.Equal(t, http.StatusUnauthorized, w.Code, name)
+			assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"), name)
+		}
+
+		w := serve(m, signToken(t, "HS256", "", secret, claims(map[string]interface{}{
+			"exp": time.Now().Add(-30 * time.Second).Unix(),
+			"nbf": time.Now().Add(30 * time.Second).Unix(),
+		})))
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, http.StatusUnauthorized, serve(NewJWTMiddleware(JWTOptions{}), valid).Code)
+
+		m = NewJWTMiddleware(JWTOptions{Secret: secret, AllowMissingExp: true})
+		assert.Equal(t, http.StatusNoContent, serve(m, signToken(t, "HS256", "", secret, unexpiring)).Code)
+	})
+
+	t.Run("verifies RS256 and ES256 tokens", func(t *testing.T) {
+		var requests int32
+		keys := map[string]interface{}{"rsa": rsaKey, "ec": ecKey}
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			_, _ = w.Write(keySet(keys))
+		}))
+		defer s.Close()
+
+		m := NewJWTMiddleware(JWTOptions{JWKSURL: s.URL})
+		assert.Equal(t, http.StatusNoContent, serve(m, signToken(t, "RS256", "rsa", rsaKey, claims(nil))).Code)
+		assert.Equal(t, http.StatusNoContent, serve(m, signToken(t, "ES256", "ec", ecKey, claims(nil))).Code)
+		assert.Equal(t, http.StatusNoContent, serve(m, signToken(t, "ES256", "", ecKey, claims(nil))).Code)
+		assert.Equal(t, http.StatusUnauthorized, serve(m, signToken(t, "ES256", "rsa", ecKey, claims(nil))).Code)
+		assert.Equal(t, http.StatusUnauthorized, serve(m, signToken(t, "RS256", "ec", rsaKey, claims(nil))).Code)
+		assert.Equal(t, http.StatusUnauthorized, serve(m, signToken(t, "HS256", "secret", []byte("secret"), claims(nil))).Code)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+		// unknown keys reload the key set at most once a minute
+		rotated, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		keys = map[string]interface{}{"rotated": rotated}
+		token := signToken(t, "ES256", "rotated", rotated, claims(nil))
+		assert.Equal(t, http.StatusUnauthorized, serve(m, token).Code)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+		m.jwks.fetched = time.Now().Add(-2 * time.Minute)
+		assert.Equal(t, http.StatusNoContent, serve(m, token).Code)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("loads key sets from files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		assert.Nil(t, os.WriteFile(path, keySet(map[string]interface{}{"rsa": rsaKey}), 0o600))
+
+		m := NewJWTMiddleware(JWTOptions{JWKSFile: path})
+		assert.Equal(t, http.StatusNoContent, serve(m, signToken(t, "RS256", "rsa", rsaKey, claims(nil))).Code)
+
+		m = NewJWTMiddleware(JWTOptions{JWKSFile: filepath.Join(t.TempDir(), "missing.json")})
+		assert.Equal(t, http.StatusUnauthorized, serve(m, signToken(t, "RS256", "rsa", rsaKey, claims(nil))).Code)
+	})
+
+	t.Run("uses stale key sets", func(t *testing.T) {
+		var status int32 = http.StatusOK
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+			_, _ = w.Write(keySet(map[string]interface{}{"rsa": rsaKey}))
+		}))
+		defer s.Close()
+
+		token := signToken(t, "RS256", "rsa", rsaKey, claims(nil))
+		m := NewJWTMiddleware(JWTOptions{JWKSURL: s.URL, JWKSCacheTTL: time.Minute})
+		assert.Equal(t, http.StatusNoContent, serve(m, token).Code)
+
+		atomic.StoreInt32(&status, http.StatusInternalServerError)
+		m.jwks.fetched = time.Now().Add(-2 * time.Minute)
+		assert.Equal(t, http.StatusNoContent, serve(m, token).Code)
+		m.jwks.wait()
+		assert.False(t, m.jwks.failed.IsZero())
+		assert.Equal(t, http.StatusNoContent, serve(m, token).Code)
+
+		m = NewJWTMiddleware(JWTOptions{JWKSURL: s.URL})
+		assert.Equal(t, http.StatusUnauthorized, serve(m, token).Code)
+
+		for _, url := range []string{"://bad", "http://127.0.0.1:0"} {
+			m = NewJWTMiddleware(JWTOptions{JWKSURL: url})
+			assert.Equal(t, http.StatusUnauthorized, serve(m, token).Code)
+		}
+
+		s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("not json"))
+		}))
+		defer s.Close()
+
+		m = NewJWTMiddleware(JWTOptions{JWKSURL: s.URL})
+		assert.Equal(t, http.StatusUnauthorized, serve(m, token).Code)
+	})
+
+	t.Run("loads key sets without the request context", func(t *testing.T) {
+		release := make(chan struct{})
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			_, _ = w.Write(keySet(map[string]interface{}{"rsa": rsaKey}))
+		}))
+		defer s.Close()
+
+		token := signToken(t, "RS256", "rsa", rsaKey, claims(nil))
+		m := NewJWTMiddleware(JWTOptions{JWKSURL: s.URL})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := m.jwks.get(ctx, "rsa")
+		assert.NotNil(t, err)
+		close(release)
+		assert.Equal(t, http.StatusNoContent, serve(m, token).Code)
+	})
+
+	t.Run("decodes claims", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Use(NewJWTMiddleware(JWTOptions{Secret: secret}))
+		r.Route("POST", "/tests", func(w http.ResponseWriter, r *http.Request) {
+			type Roles struct {
+				Roles []string `claims:"roles"`
+			}
+
+			var value struct {
+				Roles
+				UserId  uuid.UUID  `claims:"sub" json:"userId"`
+				Scope   string     `claims:"scope"`
+				Expires time.Time  `claims:"exp"`
+				Issued  *time.Time `claims:"iat"`
+				Tenant  string     `claims:"tenant"`
+				Name    string     `json:"name"`
+			}
+
+			assert.Nil(t, Parse(w, r, &value))
+			assert.Equal(t, userId, value.UserId)
+			assert.Equal(t, "read write", value.Scope)
+			assert.Equal(t, []string{"admin"}, value.Roles.Roles)
+			assert.False(t, value.Expires.IsZero())
+			assert.NotNil(t, value.Issued)
+			assert.Empty(t, value.Tenant)
+			assert.Equal(t, "test", value.Name)
+			assert.Equal(t, &userId, trail.RequestFromContext(r.Context()).UserId())
+
+			var decoded struct {
+				Scope string `json:"scope"`
+			}
+
+			assert.Nil(t, ClaimsFromContext(r.Context()).Decode(&decoded))
+			assert.Equal(t, "read write", decoded.Scope)
+			assert.NotNil(t, ClaimsFromContext(r.Context()).Decode(decoded))
+			Send(w, r, nil)
+		})
+
+		r.Route("GET", "/bad", func(w http.ResponseWriter, r *http.Request) {
+			var value struct {
+				Scope int `claims:"scope"`
+			}
+
+			Send(w, r, Parse(w, r, &value))
+		})
+
+		token := signToken(t, "HS256", "", secret, claims(map[string]interface{}{"roles": []string{"admin"}, "iat": time.Now().Unix()}))
+		req := httptest.NewRequest("POST", "/v0/tests?scope=none", strings.NewReader(`{"userId": "`+uuid.New().String()+`", "name": "test"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		req = httptest.NewRequest("GET", "/v0/bad", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
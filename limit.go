@@ -0,0 +1,211 @@
+package tea
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// BodyLimitOptions is a policy for reading request bodies
+type BodyLimitOptions struct {
+	// MaxSize is the max size of request bodies in bytes (default 64 MB)
+	// the limit applies to the decoded size of compressed bodies read by Parse
+	MaxSize int64
+
+	// ReadTimeout is the max duration for reading a request body (no limit if empty)
+	ReadTimeout time.Duration
+
+	// MinRate is the min rate request bodies must be sent at in bytes per second (no limit if empty)
+	MinRate int64
+
+	// MinRateGrace is how long clients may take before the min rate is enforced (default 5s)
+	MinRateGrace time.Duration
+}
+
+// BodyLimitMiddleware is an implementation of the request body limit middleware
+// rejecting large bodies with 413 Payload Too Large and slow clients with 408 Request Timeout
+type BodyLimitMiddleware struct {
+	opts BodyLimitOptions
+}
+
+// Handle provides an http handler for limiting request bodies
+func (m BodyLimitMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && r.Body != http.NoBody {
+			body := r.Body
+			if lb, ok := body.(*limitedBody); ok && lb.read == 0 {
+				// route limits replace the router limits
+				body = lb.ReadCloser
+			}
+
+			r.Body = newLimitedBody(w, body, m.opts)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewBodyLimitMiddleware constructs a new middleware that limits request bodies
+// it may be passed to Router.Use for all routes or Router.Route to override the limits for a route
+// e.g., r.Route("POST", "/videos", handler, NewBodyLimitMiddleware(BodyLimitOptions{MaxSize: 1 << 30}))
+func NewBodyLimitMiddleware(opts BodyLimitOptions) BodyLimitMiddleware {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = maxUploadSize
+	}
+
+	if opts.MinRateGrace <= 0 {
+		opts.MinRateGrace = 5 * time.Second
+	}
+
+	return BodyLimitMiddleware{opts: opts}
+}
+
+// bodyLimit gets the max size of the request body
+func bodyLimit(r *http.Request) int64 {
+	if lb, ok := r.Body.(*limitedBody); ok {
+		return lb.opts.MaxSize
+	}
+
+	return maxUploadSize
+}
+
+// limitedBody is a request body enforcing size and throughput limits
+type limitedBody struct {
+	io.ReadCloser
+	w         http.ResponseWriter
+	opts      BodyLimitOptions
+	remaining int64
+	read      int64
+	start     time.Time
+	deadline  bool
+	eof       bool
+	err       error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if b.eof {
+		return 0, io.EOF
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if b.start.IsZero() {
+		b.start = time.Now()
+	}
+
+	if deadline := b.next(); !deadline.IsZero() {
+		b.deadline = setReadDeadline(b.w, deadline) || b.deadline
+	}
+
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if int64(n) > b.remaining {
+		b.w.Header().Set("Connection", "close")
+		b.err = trail.NewErrorPayloadTooLarge(fmt.Sprintf("request body exceeds %d bytes", b.opts.MaxSize))
+		n, b.remaining = int(b.remaining), 0
+		return n, b.err
+	}
+
+	b.remaining -= int64(n)
+	if errors.Is(err, os.ErrDeadlineExceeded) || err == nil && b.slow() {
+		b.w.Header().Set("Connection", "close")
+		b.err = trail.NewErrorWithCode("request body read too slowly", http.StatusRequestTimeout)
+		return n, b.err
+	}
+
+	b.eof = err == io.EOF
+	return n, err
+}
+
+// Close closes the body, leaving any deadline in place for slow clients
+// so the server gives up on the rest of the body
+func (b *limitedBody) Close() error {
+	if b.deadline && b.err == nil {
+		b.deadline = false
+		setReadDeadline(b.w, time.Time{})
+	}
+
+	return b.ReadCloser.Close()
+}
+
+// next gets the deadline for the next read (if any)
+// which is extended as the client sends data at the min rate
+func (b *limitedBody) next() time.Time {
+	var deadline time.Time
+	if b.opts.ReadTimeout > 0 {
+		deadline = b.start.Add(b.opts.ReadTimeout)
+	}
+
+	if b.opts.MinRate > 0 {
+		wait := time.Duration(b.read) * time.Second / time.Duration(b.opts.MinRate)
+		if wait < b.opts.MinRateGrace {
+			wait = b.opts.MinRateGrace
+		}
+
+		if rate := b.start.Add(wait); deadline.IsZero() || rate.Before(deadline) {
+			deadline = rate
+		}
+	}
+
+	return deadline
+}
+
+// slow checks whether the client is sending the body too slowly
+func (b *limitedBody) slow() bool {
+	elapsed := time.Since(b.start)
+	if b.opts.ReadTimeout > 0 && elapsed > b.opts.ReadTimeout {
+		return true
+	}
+
+	return b.opts.MinRate > 0 && elapsed > b.opts.MinRateGrace && float64(b.read) < float64(b.opts.MinRate)*elapsed.Seconds()
+}
+
+// newLimitedBody creates a new limited request body
+func newLimitedBody(w http.ResponseWriter, body io.ReadCloser, opts BodyLimitOptions) *limitedBody {
+	return &limitedBody{
+		ReadCloser: body,
+		w:          w,
+		opts:       opts,
+		remaining:  opts.MaxSize,
+	}
+}
+
+// readError creates an error for failures reading the request body
+// preserving size and throughput limit errors
+func readError(body *limitedBody, err error) error {
+	if body.err != nil {
+		return body.err
+	}
+
+	if inner, ok := body.ReadCloser.(*limitedBody); ok {
+		return readError(inner, err)
+	}
+
+	if d, ok := body.ReadCloser.(decodedBody); ok && len(d.closers) > 0 {
+		if inner, ok := d.closers[0].(*limitedBody); ok {
+			return readError(inner, err)
+		}
+	}
+
+	return trail.ErrorBadRequest(err)
+}
+
+// setReadDeadline sets the connection read deadline if supported by the response writer
+func setReadDeadline(w http.ResponseWriter, deadline time.Time) bool {
+	return http.NewResponseController(w).SetReadDeadline(deadline) == nil
+}
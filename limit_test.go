@@ -0,0 +1,128 @@
+package tea
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// slowReader is a reader sending one byte at a time
+type slowReader struct {
+	data  string
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.EOF
+	}
+
+	time.Sleep(r.delay)
+	p[0], r.data = r.data[0], r.data[1:]
+	return 1, nil
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	t.Parallel()
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var value struct {
+			Data string `json:"data"`
+		}
+
+		if err := Parse(w, r, &value); err != nil {
+			Send(w, r, err)
+			return
+		}
+
+		Send(w, r, value.Data)
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		m := NewBodyLimitMiddleware(BodyLimitOptions{})
+		assert.Equal(t, int64(maxUploadSize), m.opts.MaxSize)
+		assert.Equal(t, 5*time.Second, m.opts.MinRateGrace)
+		assert.Equal(t, int64(maxUploadSize), bodyLimit(httptest.NewRequest("POST", "/tests", nil)))
+		assert.False(t, setReadDeadline(httptest.NewRecorder(), time.Now()))
+	})
+
+	t.Run("limits router and route bodies", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Use(NewBodyLimitMiddleware(BodyLimitOptions{MaxSize: 16}))
+		r.Route("POST", "/small", handler)
+		r.Route("POST", "/large", handler, NewBodyLimitMiddleware(BodyLimitOptions{MaxSize: 1024}))
+		r.Route("POST", "/raw", func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			assert.True(t, trail.IsPayloadTooLarge(err))
+		})
+
+		body := `{"data": "` + strings.Repeat("a", 32) + `"}`
+		for path, status := range map[string]int{"/small": http.StatusRequestEntityTooLarge, "/large": http.StatusOK} {
+			req := httptest.NewRequest("POST", "/v0"+path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, status, w.Code, path)
+			if status == http.StatusRequestEntityTooLarge {
+				assert.Equal(t, "close", w.Header().Get("Connection"))
+			}
+		}
+
+		req := httptest.NewRequest("POST", "/v0/small", strings.NewReader(`{"data": "a"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v0/raw", strings.NewReader(body)))
+	})
+
+	t.Run("limits slow clients", func(t *testing.T) {
+		for _, opts := range []BodyLimitOptions{
+			{ReadTimeout: 50 * time.Millisecond},
+			{MinRate: 1000, MinRateGrace: 20 * time.Millisecond},
+		} {
+			req := httptest.NewRequest("POST", "/tests", io.NopCloser(&slowReader{data: `{"data": "test"}`, delay: 10 * time.Millisecond}))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			NewBodyLimitMiddleware(opts).Handle(http.HandlerFunc(handler)).ServeHTTP(w, req)
+			assert.Equal(t, http.StatusRequestTimeout, w.Code)
+			assert.Equal(t, "close", w.Header().Get("Connection"))
+		}
+
+		req := httptest.NewRequest("POST", "/tests", io.NopCloser(&slowReader{data: `{"data": "test"}`}))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		NewBodyLimitMiddleware(BodyLimitOptions{ReadTimeout: time.Second, MinRate: 1}).Handle(http.HandlerFunc(handler)).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("limits stalled connections", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Use(NewBodyLimitMiddleware(BodyLimitOptions{ReadTimeout: 100 * time.Millisecond}))
+		r.Route("POST", "/tests", handler)
+		s := httptest.NewServer(r)
+		defer s.Close()
+
+		conn, err := net.Dial("tcp", s.Listener.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		start := time.Now()
+		_, _ = conn.Write([]byte("POST /v0/tests HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"data\""))
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusRequestTimeout, resp.StatusCode)
+		assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	})
+}
@@ -0,0 +1,192 @@
+package tea
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// directorMatch is the set of request conditions a director applies to
+type directorMatch struct {
+	hosts   []string
+	headers map[string]string
+	query   map[string]string
+	methods []string
+}
+
+// key gets the identity of the director in the route table
+// (the path prefix for directors without further conditions)
+func (d *director) key() string {
+	key := d.prefix
+	if len(d.match.hosts) > 0 {
+		key += " hosts=" + strings.Join(d.match.hosts, ",")
+	}
+
+	if len(d.match.headers) > 0 {
+		key += " headers=" + pairs(d.match.headers)
+	}
+
+	if len(d.match.query) > 0 {
+		key += " query=" + pairs(d.match.query)
+	}
+
+	if len(d.match.methods) > 0 {
+		key += " methods=" + strings.Join(d.match.methods, ",")
+	}
+
+	return key
+}
+
+// matches checks whether the request meets all of the director's conditions
+// the host rank is 2 for exact host matches, 1 for wildcard host matches and 0 otherwise
+func (d *director) matches(r *http.Request, urlPath string) (int, bool) {
+	if prefix := strings.Trim(d.prefix, "/"); prefix != "" && urlPath != prefix && !strings.HasPrefix(urlPath, prefix+"/") {
+		return 0, false
+	}
+
+	rank, ok := matchHost(d.match.hosts, r.Host)
+	if !ok {
+		return 0, false
+	}
+
+	if len(d.match.methods) > 0 && !contains(d.match.methods, r.Method) {
+		return 0, false
+	}
+
+	for name, value := range d.match.headers {
+		if values := r.Header.Values(name); len(values) == 0 || (value != "" && !contains(values, value)) {
+			return 0, false
+		}
+	}
+
+	query := r.URL.Query()
+	for key, value := range d.match.query {
+		if values, present := query[key]; !present || (value != "" && !contains(values, value)) {
+			return 0, false
+		}
+	}
+
+	return rank, true
+}
+
+// precedes checks whether the director takes precedence over another matching director
+// preferring in order: exact hosts over wildcard hosts over any host, longer path prefixes,
+// more header and query conditions, method conditions and finally the lowest key
+func (d *director) precedes(rank int, other *director, otherRank int) bool {
+	if rank != otherRank {
+		return rank > otherRank
+	}
+
+	if a, b := len(strings.Trim(d.prefix, "/")), len(strings.Trim(other.prefix, "/")); a != b {
+		return a > b
+	}
+
+	if a, b := len(d.match.headers)+len(d.match.query), len(other.match.headers)+len(other.match.query); a != b {
+		return a > b
+	}
+
+	if a, b := len(d.match.methods) > 0, len(other.match.methods) > 0; a != b {
+		return a
+	}
+
+	return d.key() < other.key()
+}
+
+// matchHost checks whether the host matches any of the hosts (e.g., tenant.pghq.app or *.pghq.app)
+func matchHost(hosts []string, host string) (int, bool) {
+	if len(hosts) == 0 {
+		return 0, true
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(host)
+	rank := 0
+	for _, pattern := range hosts {
+		if pattern == host {
+			return 2, true
+		}
+
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) && len(host) > len(pattern)-1 {
+			rank = 1
+		}
+	}
+
+	return rank, rank > 0
+}
+
+// pairs formats conditions in a canonical order
+func pairs(m map[string]string) string {
+	var s []string
+	for k, v := range m {
+		s = append(s, k+":"+v)
+	}
+
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+// contains checks whether the value is in the values
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithHostMatch creates an option directing only requests for the hosts
+// wildcard subdomains (e.g., *.pghq.app) match with lower precedence than exact hosts
+func WithHostMatch(hosts ...string) DirectorOption {
+	return func(d *director) error {
+		for _, host := range hosts {
+			d.match.hosts = append(d.match.hosts, strings.ToLower(host))
+		}
+
+		sort.Strings(d.match.hosts)
+		return nil
+	}
+}
+
+// WithHeaderMatch creates an option directing only requests with the header value
+// (any value if empty, e.g., for tenant or canary headers)
+func WithHeaderMatch(name, value string) DirectorOption {
+	return func(d *director) error {
+		if d.match.headers == nil {
+			d.match.headers = make(map[string]string)
+		}
+
+		d.match.headers[http.CanonicalHeaderKey(name)] = value
+		return nil
+	}
+}
+
+// WithQueryMatch creates an option directing only requests with the query parameter value
+// (any value if empty)
+func WithQueryMatch(key, value string) DirectorOption {
+	return func(d *director) error {
+		if d.match.query == nil {
+			d.match.query = make(map[string]string)
+		}
+
+		d.match.query[key] = value
+		return nil
+	}
+}
+
+// WithMethodMatch creates an option directing only requests with the methods
+func WithMethodMatch(methods ...string) DirectorOption {
+	return func(d *director) error {
+		for _, method := range methods {
+			d.match.methods = append(d.match.methods, strings.ToUpper(method))
+		}
+
+		sort.Strings(d.match.methods)
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package tea
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirector_Matches(t *testing.T) {
+	t.Parallel()
+
+	newDirector := func(t *testing.T, prefix string, opts ...DirectorOption) *director {
+		d := director{prefix: prefix}
+		for _, opt := range opts {
+			assert.Nil(t, opt(&d))
+		}
+
+		return &d
+	}
+
+	matches := func(d *director, r *http.Request) (int, bool) {
+		return d.matches(r, r.URL.Path[1:])
+	}
+
+	t.Run("path prefix", func(t *testing.T) {
+		d := newDirector(t, "users")
+		for path, ok := range map[string]bool{"/users": true, "/users/1": true, "/usersx": false, "/accounts/users": false} {
+			_, matched := matches(d, httptest.NewRequest("GET", path, nil))
+			assert.Equal(t, ok, matched, path)
+		}
+
+		_, matched := matches(newDirector(t, ""), httptest.NewRequest("GET", "/anything", nil))
+		assert.True(t, matched)
+	})
+
+	t.Run("host", func(t *testing.T) {
+		d := newDirector(t, "", WithHostMatch("Tenant.pghq.app", "*.pghq.app"))
+		r := httptest.NewRequest("GET", "/", nil)
+		for host, rank := range map[string]int{"tenant.pghq.app": 2, "tenant.pghq.app:8080": 2, "other.pghq.app": 1, "a.b.pghq.app": 1, "pghq.app": 0, "evilpghq.app": 0} {
+			r.Host = host
+			got, ok := matches(d, r)
+			assert.Equal(t, rank, got, host)
+			assert.Equal(t, rank > 0, ok, host)
+		}
+	})
+
+	t.Run("headers, query and methods", func(t *testing.T) {
+		d := newDirector(t, "", WithHeaderMatch("x-tenant", "a"), WithHeaderMatch("X-Canary", ""), WithQueryMatch("v", "2"), WithMethodMatch("get", "post"))
+		r := httptest.NewRequest("GET", "/?v=1&v=2", nil)
+		r.Header.Set("X-Tenant", "a")
+		r.Header.Set("X-Canary", "true")
+		_, ok := matches(d, r)
+		assert.True(t, ok)
+
+		r.Method = "DELETE"
+		_, ok = matches(d, r)
+		assert.False(t, ok)
+
+		r.Method = "POST"
+		r.Header.Del("X-Canary")
+		_, ok = matches(d, r)
+		assert.False(t, ok)
+
+		r.Header.Set("X-Canary", "true")
+		r.Header.Set("X-Tenant", "b")
+		_, ok = matches(d, r)
+		assert.False(t, ok)
+
+		r.Header.Set("X-Tenant", "a")
+		r.URL.RawQuery = "v=1"
+		_, ok = matches(d, r)
+		assert.False(t, ok)
+	})
+
+	t.Run("key", func(t *testing.T) {
+		assert.Equal(t, "users", newDirector(t, "users").key())
+		a := newDirector(t, "users", WithHostMatch("b.tld", "a.tld"), WithQueryMatch("v", "2"), WithHeaderMatch("x-b", "2"), WithHeaderMatch("x-a", "1"), WithMethodMatch("GET"))
+		b := newDirector(t, "users", WithMethodMatch("GET"), WithHeaderMatch("X-A", "1"), WithHeaderMatch("X-B", "2"), WithQueryMatch("v", "2"), WithHostMatch("a.tld", "b.tld"))
+		assert.Equal(t, "users hosts=a.tld,b.tld headers=X-A:1,X-B:2 query=v:2 methods=GET", a.key())
+		assert.Equal(t, a.key(), b.key())
+	})
+
+	t.Run("precedence", func(t *testing.T) {
+		ordered := []*director{
+			newDirector(t, "", WithHostMatch("tenant.pghq.app")),
+			newDirector(t, "users", WithHostMatch("*.pghq.app")),
+			newDirector(t, "users/admin"),
+			newDirector(t, "users", WithHeaderMatch("X-Canary", ""), WithQueryMatch("v", "")),
+			newDirector(t, "users", WithHeaderMatch("X-Canary", "")),
+			newDirector(t, "users", WithQueryMatch("w", "")),
+			newDirector(t, "users", WithMethodMatch("GET")),
+			newDirector(t, "users"),
+		}
+
+		r := httptest.NewRequest("GET", "/users/admin?v=1&w=1", nil)
+		r.Host = "tenant.pghq.app"
+		r.Header.Set("X-Canary", "true")
+		for i := 0; i < len(ordered)-1; i++ {
+			rank, ok := matches(ordered[i], r)
+			assert.True(t, ok)
+			nextRank, ok := matches(ordered[i+1], r)
+			assert.True(t, ok)
+			assert.True(t, ordered[i].precedes(rank, ordered[i+1], nextRank), ordered[i].key())
+			assert.False(t, ordered[i+1].precedes(nextRank, ordered[i], rank), ordered[i].key())
+		}
+	})
+}
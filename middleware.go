@@ -2,6 +2,8 @@ package tea
 
 import (
 	"net/http"
+	"path"
+	"sort"
 )
 
 // Middleware represents a handler that is called to for example,
@@ -19,3 +21,81 @@ type MiddlewareFunc func(http.Handler) http.Handler
 func (m MiddlewareFunc) Handle(h http.Handler) http.Handler {
 	return m(h)
 }
+
+// MiddlewareOption is a handler for configuring how router middleware is applied
+type MiddlewareOption func(m *routerMiddleware)
+
+// PreRouting creates an option for running middleware before the request is routed
+// so that it applies to every request, including not found and method not allowed ones
+// (only applies to top level routers; group middleware always runs after routing)
+func PreRouting() MiddlewareOption {
+	return func(m *routerMiddleware) {
+		m.phase = preRouting
+	}
+}
+
+// WithPriority creates a middleware priority option
+// middleware with a higher priority runs first, equal priorities run in registration order
+func WithPriority(priority int) MiddlewareOption {
+	return func(m *routerMiddleware) {
+		m.priority = priority
+	}
+}
+
+// Except creates an option for excluding routes from the middleware
+// routes are matched by their path template relative to the service prefix
+// (e.g., "/health/status", "/v1/orders/{id}" or "/v1/orders/*")
+func Except(templates ...string) MiddlewareOption {
+	return func(m *routerMiddleware) {
+		m.except = append(m.except, templates...)
+	}
+}
+
+// middlewarePhase is the stage of request handling a router middleware runs in
+type middlewarePhase int
+
+const (
+	// postRouting middleware runs after the request is matched to a route
+	postRouting middlewarePhase = iota
+
+	// preRouting middleware runs before the request is matched to a route
+	preRouting
+)
+
+// routerMiddleware is a middleware registered on a router
+type routerMiddleware struct {
+	Middleware
+	phase    middlewarePhase
+	priority int
+	except   []string
+}
+
+// excludes checks whether the route is excluded from the middleware
+func (m routerMiddleware) excludes(template string) bool {
+	for _, pattern := range m.except {
+		if ok, _ := path.Match(pattern, template); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pipeline creates a handler running the middlewares (ordered by priority) before the next handler
+// for the route with the template (middlewares excluding the route are skipped)
+func pipeline(middlewares []routerMiddleware, template string, next http.Handler) http.Handler {
+	sort.SliceStable(middlewares, func(i, j int) bool {
+		return middlewares[i].priority > middlewares[j].priority
+	})
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		m := middlewares[i]
+		if m.excludes(template) {
+			continue
+		}
+
+		next = m.Handle(next)
+	}
+
+	return next
+}
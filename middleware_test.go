@@ -3,7 +3,9 @@ package tea
 import (
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,13 +24,13 @@ func TestMiddleware_Handle(t *testing.T) {
 
 func TestCORS(t *testing.T) {
 	t.Run("can create instance", func(t *testing.T) {
-		assert.NotNil(t, NewCORSMiddleware())
+		assert.NotNil(t, NewCORSMiddleware(CORSOptions{}))
 	})
 }
 
 func TestCORSMiddleware_Handle(t *testing.T) {
 	t.Run("handles cors for request with no origin", func(t *testing.T) {
-		m := NewCORSMiddleware()
+		m := NewCORSMiddleware(CORSOptions{})
 		r := httptest.NewRequest("OPTIONS", "/tests", nil)
 		r.Header.Set("Origin", "https://tea.pghq.app")
 		w := httptest.NewRecorder()
@@ -41,7 +43,7 @@ func TestCORSMiddleware_Handle(t *testing.T) {
 	})
 
 	t.Run("handles cors for request with no matching origin", func(t *testing.T) {
-		m := NewCORSMiddleware()
+		m := NewCORSMiddleware(CORSOptions{})
 		r := httptest.NewRequest("OPTIONS", "/tests", nil)
 		r.Header.Set("Origin", "https://test.site.tld")
 		r.Header.Set("Access-Control-Request-Method", "GET")
@@ -56,3 +58,125 @@ func TestCORSMiddleware_Handle(t *testing.T) {
 		assert.Contains(t, w.Header().Values("Vary"), "Origin")
 	})
 }
+
+func TestCORSOptions(t *testing.T) {
+	t.Run("allows origins", func(t *testing.T) {
+		opts := CORSOptions{
+			AllowedOrigins:        []string{"https://tea.pghq.app", "https://*.pghq.dev"},
+			AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^https://pr-\d+\.pghq\.io$`)},
+		}
+
+		assert.True(t, CORSOptions{}.allowsOrigin("https://test.site.tld"))
+		assert.True(t, opts.allowsOrigin("https://tea.pghq.app"))
+		assert.True(t, opts.allowsOrigin("https://api.pghq.dev"))
+		assert.True(t, opts.allowsOrigin("https://a.b.pghq.dev"))
+		assert.True(t, opts.allowsOrigin("https://pr-12.pghq.io"))
+		assert.False(t, opts.allowsOrigin("https://pghq.dev"))
+		assert.False(t, opts.allowsOrigin("https://api.pghq.app"))
+		assert.False(t, opts.allowsOrigin("https://pr-x.pghq.io"))
+	})
+
+	t.Run("sets response headers", func(t *testing.T) {
+		m := NewCORSMiddleware(CORSOptions{
+			AllowedOrigins:   []string{"https://*.pghq.app"},
+			ExposedHeaders:   []string{"X-Total"},
+			MaxAge:           time.Hour,
+			AllowCredentials: true,
+		})
+
+		r := httptest.NewRequest("GET", "/tests", nil)
+		r.Header.Set("Origin", "https://tea.pghq.app")
+		w := httptest.NewRecorder()
+		m.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		assert.Equal(t, "https://tea.pghq.app", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "Request-Id, X-Total", w.Header().Get("Access-Control-Expose-Headers"))
+
+		r = httptest.NewRequest("OPTIONS", "/tests", nil)
+		r.Header.Set("Origin", "https://tea.pghq.app")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		w = httptest.NewRecorder()
+		m.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		assert.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"))
+
+		r = httptest.NewRequest("GET", "/tests", nil)
+		r.Header.Set("Origin", "https://tea.pghq.dev")
+		w = httptest.NewRecorder()
+		m.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("requires explicit origins for credentials", func(t *testing.T) {
+		for _, origins := range [][]string{nil, {"*"}} {
+			opts := CORSOptions{AllowedOrigins: origins, AllowCredentials: true}
+			assert.False(t, opts.allowsOrigin("https://evil.example"))
+
+			r := httptest.NewRequest("GET", "/tests", nil)
+			r.Header.Set("Origin", "https://evil.example")
+			w := httptest.NewRecorder()
+			NewCORSMiddleware(opts).Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+			assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+		}
+
+		opts := CORSOptions{AllowedOrigins: []string{"*", "https://tea.pghq.app"}, AllowCredentials: true}
+		assert.True(t, opts.allowsOrigin("https://tea.pghq.app"))
+		assert.False(t, opts.allowsOrigin("https://evil.example"))
+		assert.True(t, CORSOptions{AllowedOrigins: []string{"*"}}.allowsOrigin("https://evil.example"))
+	})
+}
+
+func TestRouter_CORS(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Route("GET", "/tests", ok)
+
+		req := httptest.NewRequest("GET", "/v0/tests", nil)
+		req.Header.Set("Origin", "https://tea.pghq.app")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("router policy", func(t *testing.T) {
+		r := NewRouter("0", WithCORS(CORSOptions{AllowedOrigins: []string{"https://tea.pghq.app"}}))
+		r.Route("GET", "/tests", ok)
+
+		req := httptest.NewRequest("OPTIONS", "/v0/tests", nil)
+		req.Header.Set("Origin", "https://tea.pghq.app")
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "https://tea.pghq.app", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET", w.Header().Get("Access-Control-Allow-Methods"))
+		assert.Empty(t, w.Header().Get("Allow"))
+
+		req = httptest.NewRequest("OPTIONS", "/v0/tests", nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+	})
+
+	t.Run("route override", func(t *testing.T) {
+		r := NewRouter("0", WithCORS(CORSOptions{AllowedOrigins: []string{"https://tea.pghq.app"}}))
+		r.Route("GET", "/tests", ok)
+		r.Route("POST", "/public", ok, NewCORSMiddleware(CORSOptions{}))
+
+		req := httptest.NewRequest("OPTIONS", "/v0/public", nil)
+		req.Header.Set("Origin", "https://test.site.tld")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, "https://test.site.tld", w.Header().Get("Access-Control-Allow-Origin"))
+
+		req = httptest.NewRequest("GET", "/v0/tests", nil)
+		req.Header.Set("Origin", "https://test.site.tld")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
@@ -0,0 +1,287 @@
+package tea
+
+import (
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gorilla/schema"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+var (
+	// formDec is a global multipart form value decoder.
+	formDec *schema.Decoder
+
+	// fileHeaderType is the type of multipart file fields
+	fileHeaderType = reflect.TypeOf(new(multipart.FileHeader))
+
+	// readerType is the type of multipart reader fields
+	readerType = reflect.TypeOf(new(io.Reader)).Elem()
+)
+
+func init() {
+	formDec = schema.NewDecoder()
+	formDec.ZeroEmpty(true)
+	formDec.IgnoreUnknownKeys(true)
+	formDec.SetAliasTag("form")
+}
+
+// MultipartOptions is a policy for decoding multipart/form-data uploads
+type MultipartOptions struct {
+	// MaxSize is the max size of an upload in bytes (defaults to the body limit)
+	MaxSize int64
+
+	// MaxMemory is the max size of an upload held in memory (default 32 MB)
+	// the remainder of larger files is stored in temporary files until the request finishes
+	// (without the middleware uploads are held in memory up to the max size)
+	MaxMemory int64
+}
+
+// MultipartMiddleware is an implementation of the multipart upload middleware
+// configuring how Parse decodes multipart/form-data requests
+type MultipartMiddleware struct {
+	opts MultipartOptions
+}
+
+// Handle provides an http handler for decoding multipart uploads
+func (m MultipartMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := &upload{opts: m.opts}
+		defer u.close()
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), uploadContextKey{}, u)))
+	})
+}
+
+// NewMultipartMiddleware constructs a new middleware that configures multipart uploads
+// it may be passed to Router.Use for all routes or Router.Route to override the limits for a route
+// e.g., r.Route("POST", "/avatars", handler, NewMultipartMiddleware(MultipartOptions{MaxSize: 8 << 20}))
+func NewMultipartMiddleware(opts MultipartOptions) MultipartMiddleware {
+	if opts.MaxMemory <= 0 {
+		opts.MaxMemory = 32 << 20
+	}
+
+	return MultipartMiddleware{opts: opts}
+}
+
+// uploadContextKey is the context key for multipart uploads
+type uploadContextKey struct{}
+
+// upload is a multipart upload read for a request
+type upload struct {
+	opts  MultipartOptions
+	form  *multipart.Form
+	files []multipart.File
+}
+
+// close removes any temporary files once the request finishes
+func (u *upload) close() {
+	for _, f := range u.files {
+		_ = f.Close()
+	}
+
+	if u.form != nil {
+		_ = u.form.RemoveAll()
+	}
+}
+
+// multipartDecoder decodes multipart/form-data requests
+// files are decoded into io.Reader, *multipart.FileHeader and []*multipart.FileHeader fields
+// and values into any other fields supported by the schema decoder
+// files without a matching field are discarded, so parsing the request again only sees the files decoded the first time
+type multipartDecoder struct {
+	w http.ResponseWriter
+	r *http.Request
+}
+
+func (d multipartDecoder) decode(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	u, _ := d.r.Context().Value(uploadContextKey{}).(*upload)
+	if u == nil {
+		// without the middleware uploads are held in memory (up to the body limit)
+		// so nothing is left on disk once the upload is closed
+		u = &upload{}
+		defer u.close()
+	}
+
+	if u.form == nil && d.r.MultipartForm != nil {
+		u.form = d.r.MultipartForm
+	}
+
+	if u.form == nil {
+		form, err := d.form(u.opts, fileFields(rv.Type()))
+		if err != nil {
+			return err
+		}
+
+		u.form = form
+	}
+
+	d.r.MultipartForm = u.form
+	values := make(map[string][]string, len(u.form.Value))
+	for key, value := range u.form.Value {
+		values[key] = value
+	}
+
+	t := rv.Type()
+	for i := 0; i < rv.NumField(); i++ {
+		field := t.Field(i)
+		v := rv.Field(i)
+
+		key := strings.Split(field.Tag.Get("form"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+
+		files := u.form.File[key]
+		switch {
+		case v.Type() == fileHeaderType:
+			delete(values, key)
+			if v.CanSet() && len(files) > 0 {
+				v.Set(reflect.ValueOf(files[0]))
+			}
+		case v.Type() == reflect.SliceOf(fileHeaderType):
+			delete(values, key)
+			if v.CanSet() && len(files) > 0 {
+				v.Set(reflect.ValueOf(files))
+			}
+		case v.Kind() == reflect.Interface && v.Type().Implements(readerType):
+			value, present := values[key]
+			delete(values, key)
+			if !v.CanSet() {
+				continue
+			}
+
+			if len(files) > 0 {
+				f, err := files[0].Open()
+				if err != nil {
+					return trail.Stacktrace(err)
+				}
+
+				u.files = append(u.files, f)
+				v.Set(reflect.ValueOf(f))
+				continue
+			}
+
+			if present && reflect.TypeOf(strings.NewReader("")).AssignableTo(v.Type()) {
+				v.Set(reflect.ValueOf(strings.NewReader(value[0])))
+				continue
+			}
+
+			return trail.NewErrorBadRequest("missing part " + key)
+		}
+	}
+
+	if err := formDec.Decode(v, values); err != nil {
+		return trail.ErrorBadRequest(err)
+	}
+
+	return nil
+}
+
+// form reads the multipart form from the request body in a single pass
+// parts are read one at a time and file parts without a matching field are skipped rather than stored
+func (d multipartDecoder) form(opts MultipartOptions, files map[string]bool) (*multipart.Form, error) {
+	limit := opts.MaxSize
+	if limit <= 0 {
+		limit = bodyLimit(d.r)
+	}
+
+	maxMemory := opts.MaxMemory
+	if maxMemory <= 0 {
+		maxMemory = limit
+	}
+
+	body, err := bodyReader(d.w, d.r, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+	d.r.Body = body
+	reader, err := d.r.MultipartReader()
+	if err != nil {
+		return nil, trail.ErrorBadRequest(err)
+	}
+
+	// the parts kept are streamed to ReadForm which stores them in memory or temporary files
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = pw.CloseWithError(copyParts(mw, reader, files))
+	}()
+
+	form, err := multipart.NewReader(pr, mw.Boundary()).ReadForm(maxMemory)
+	_ = pr.Close()
+	<-done
+	if err != nil {
+		return nil, readError(body, err)
+	}
+
+	return form, nil
+}
+
+// copyParts copies the value parts and the file parts with the names to the writer
+func copyParts(mw *multipart.Writer, reader *multipart.Reader, files map[string]bool) error {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return mw.Close()
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if part.FileName() != "" && !files[part.FormName()] {
+			continue
+		}
+
+		w, err := mw.CreatePart(part.Header)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(w, part); err != nil {
+			return err
+		}
+	}
+}
+
+// fileFields gets the names of the fields files are decoded into
+func fileFields(t reflect.Type) map[string]bool {
+	files := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("form"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+
+		ft := field.Type
+		if ft == fileHeaderType || ft == reflect.SliceOf(fileHeaderType) || ft.Kind() == reflect.Interface && ft.Implements(readerType) {
+			files[key] = true
+		}
+	}
+
+	return files
+}
+
+// newMultipartDecoder creates a new multipart/form-data decoder
+func newMultipartDecoder(w http.ResponseWriter, r *http.Request) *multipartDecoder {
+	return &multipartDecoder{
+		w: w,
+		r: r,
+	}
+}
@@ -0,0 +1,200 @@
+package tea
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+func TestMultipartDecoder(t *testing.T) {
+	t.Parallel()
+
+	newRequest := func(fields map[string]string, files ...string) *http.Request {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		for key, value := range fields {
+			_ = mw.WriteField(key, value)
+		}
+
+		for i, file := range files {
+			name, content, _ := strings.Cut(file, "=")
+			mp, _ := mw.CreateFormFile(name, strings.Repeat("f", i+1)+".csv")
+			_, _ = mp.Write([]byte(content))
+		}
+
+		_ = mw.Close()
+		req := httptest.NewRequest("POST", "/tests", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req
+	}
+
+	t.Run("raises bad request body errors", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/tests", iotest.ErrReader(trail.NewError("an error has occurred")))
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=test")
+		err := Parse(httptest.NewRecorder(), req, &struct{}{})
+		assert.True(t, trail.IsBadRequest(err))
+	})
+
+	t.Run("raises content type errors", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/tests", strings.NewReader(`{"data": "test"}`))
+		req.Header.Set("Content-Type", "multipart/form-data")
+		err := Parse(httptest.NewRecorder(), req, &struct{}{})
+		assert.True(t, trail.IsBadRequest(err))
+	})
+
+	t.Run("raises value errors", func(t *testing.T) {
+		var value struct {
+			Count int `form:"count"`
+		}
+
+		err := Parse(httptest.NewRecorder(), newRequest(map[string]string{"count": "many"}), &value)
+		assert.True(t, trail.IsBadRequest(err))
+	})
+
+	t.Run("raises missing part errors", func(t *testing.T) {
+		var value struct {
+			Avatar io.Reader `form:"avatar"`
+		}
+
+		err := Parse(httptest.NewRecorder(), newRequest(nil), &value)
+		assert.True(t, trail.IsBadRequest(err))
+	})
+
+	t.Run("can decode files and values", func(t *testing.T) {
+		var value struct {
+			Name        string                  `form:"name"`
+			Count       int                     `form:"count"`
+			Tags        []string                `form:"tags"`
+			Avatar      *multipart.FileHeader   `form:"avatar"`
+			Attachments []*multipart.FileHeader `form:"attachments"`
+			File        io.Reader               `form:"file"`
+			Text        io.Reader               `form:"text"`
+			Missing     *multipart.FileHeader   `form:"missing"`
+			Ignored     string                  `form:"-"`
+			file        io.Reader               `form:"file"`
+		}
+
+		req := newRequest(map[string]string{"name": "test", "count": "2", "tags": "a", "text": "hello", "Ignored": "yes"}, "avatar=image", "attachments=a", "attachments=b", "file=example")
+		err := Parse(httptest.NewRecorder(), req, &value)
+		assert.Nil(t, err)
+		assert.Equal(t, "test", value.Name)
+		assert.Equal(t, 2, value.Count)
+		assert.Equal(t, []string{"a"}, value.Tags)
+		assert.Equal(t, "f.csv", value.Avatar.Filename)
+		assert.Len(t, value.Attachments, 2)
+		assert.Nil(t, value.Missing)
+		assert.Empty(t, value.Ignored)
+		assert.Nil(t, value.file)
+
+		data, _ := io.ReadAll(value.File)
+		assert.Equal(t, "example", string(data))
+		data, _ = io.ReadAll(value.Text)
+		assert.Equal(t, "hello", string(data))
+		assert.NotNil(t, req.MultipartForm)
+
+		t.Run("reuses the form", func(t *testing.T) {
+			var again struct {
+				Name string `form:"name"`
+			}
+
+			assert.Nil(t, Parse(httptest.NewRecorder(), req, &again))
+			assert.Equal(t, "test", again.Name)
+		})
+	})
+
+	t.Run("limits uploads per route", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Route("POST", "/small", func(w http.ResponseWriter, r *http.Request) {
+			var value struct {
+				File *multipart.FileHeader `form:"file"`
+			}
+
+			if err := Parse(w, r, &value); err != nil {
+				Send(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		}, NewMultipartMiddleware(MultipartOptions{MaxSize: 512}))
+
+		req := newRequest(nil, "file="+strings.Repeat("a", 1024))
+		req.URL.Path = "/v0/small"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+		req = newRequest(nil, "file=a")
+		req.URL.Path = "/v0/small"
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("stores large files on disk", func(t *testing.T) {
+		var name string
+		r := NewRouter("0")
+		r.Use(NewMultipartMiddleware(MultipartOptions{MaxMemory: 1}))
+		r.Route("POST", "/uploads", func(w http.ResponseWriter, r *http.Request) {
+			var value struct {
+				File io.Reader `form:"file"`
+			}
+
+			assert.Nil(t, Parse(w, r, &value))
+			f, ok := value.File.(*os.File)
+			assert.True(t, ok)
+			name = f.Name()
+			_, err := os.Stat(name)
+			assert.Nil(t, err)
+		})
+
+		req := newRequest(nil, "file="+strings.Repeat("a", 1024))
+		req.URL.Path = "/v0/uploads"
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		assert.NotEmpty(t, name)
+		_, err := os.Stat(name)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("skips unknown files", func(t *testing.T) {
+		var value struct {
+			Name   string                `form:"name"`
+			Avatar *multipart.FileHeader `form:"avatar"`
+		}
+
+		req := newRequest(map[string]string{"name": "test"}, "avatar=image", "unknown="+strings.Repeat("a", 1024))
+		assert.Nil(t, Parse(httptest.NewRecorder(), req, &value))
+		assert.Equal(t, "test", value.Name)
+		assert.Equal(t, "f.csv", value.Avatar.Filename)
+		assert.Contains(t, req.MultipartForm.File, "avatar")
+		assert.NotContains(t, req.MultipartForm.File, "unknown")
+	})
+
+	t.Run("holds uploads in memory without the middleware", func(t *testing.T) {
+		var value struct {
+			File io.Reader `form:"file"`
+		}
+
+		r := NewRouter("0")
+		r.Route("POST", "/uploads", func(w http.ResponseWriter, r *http.Request) {
+			assert.Nil(t, Parse(w, r, &value))
+			_, ok := value.File.(*os.File)
+			assert.False(t, ok)
+		})
+
+		req := newRequest(nil, "file="+strings.Repeat("a", 64<<10))
+		req.URL.Path = "/v0/uploads"
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		data, _ := io.ReadAll(value.File)
+		assert.Len(t, data, 64<<10)
+	})
+}
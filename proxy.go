@@ -3,10 +3,11 @@ package tea
 import (
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/hashicorp/go-version"
 
@@ -16,11 +17,15 @@ import (
 
 // Proxy is a multi-host reverse proxy
 type Proxy struct {
-	directors   map[string]*httputil.ReverseProxy
+	directors   atomic.Value // map[string]*director
+	mutex       sync.Mutex
+	files       map[string][]string
+	admin       string
 	middlewares []Middleware
 	cors        Middleware
 	trace       MiddlewareFunc
 	health      *health.Service
+	resolver    *trail.IPResolver
 }
 
 // Middleware adds a middleware to the proxy
@@ -30,46 +35,210 @@ func (p *Proxy) Middleware(middlewares ...Middleware) {
 
 // Direct sets a new director for the path
 // e.g., pathPrefix is typically the name of the microservice
-func (p *Proxy) Direct(pathPrefix, host string) error {
-	hostURL, err := url.ParseRequestURI(host)
+// directors may further match requests by host, header, query or method (e.g., WithHostMatch)
+// and the most specific matching director handles each request
+func (p *Proxy) Direct(pathPrefix, host string, opts ...DirectorOption) error {
+	d, err := p.director(pathPrefix, append([]DirectorOption{WithUpstreams(host)}, opts...)...)
 	if err != nil {
 		return trail.Stacktrace(err)
 	}
 
-	p.directors[pathPrefix] = &httputil.ReverseProxy{
-		Director: func(r *http.Request) {
-			r.Header.Add("X-Forwarded-Host", r.Host)
-			r.Header.Add("X-Forwarded-Proto", r.URL.Scheme)
-			r.URL.Host = hostURL.Host
-			r.URL.Scheme = hostURL.Scheme
+	return p.update(func(directors map[string]*director) error {
+		if _, present := directors[d.key()]; present {
+			return trail.NewErrorConflict("director already exists for " + d.key())
+		}
+
+		directors[d.key()] = d
+		return nil
+	})
+}
+
+// Replace atomically replaces the director for the path (and match conditions)
+func (p *Proxy) Replace(pathPrefix, host string, opts ...DirectorOption) error {
+	d, err := p.director(pathPrefix, append([]DirectorOption{WithUpstreams(host)}, opts...)...)
+	if err != nil {
+		return trail.Stacktrace(err)
+	}
+
+	return p.update(func(directors map[string]*director) error {
+		if _, present := directors[d.key()]; !present {
+			return trail.NewErrorNotFound("no director for " + d.key())
+		}
+
+		directors[d.key()] = d
+		return nil
+	})
+}
+
+// Remove removes the director for the path and match conditions (e.g., WithHostMatch)
+func (p *Proxy) Remove(pathPrefix string, matches ...DirectorOption) error {
+	d := director{prefix: pathPrefix, pool: newPool()}
+	for _, opt := range matches {
+		if err := opt(&d); err != nil {
+			return trail.Stacktrace(err)
+		}
+	}
+
+	return p.update(func(directors map[string]*director) error {
+		if _, present := directors[d.key()]; !present {
+			return trail.NewErrorNotFound("no director for " + d.key())
+		}
+
+		delete(directors, d.key())
+		return nil
+	})
+}
+
+// director creates a new director for the path
+func (p *Proxy) director(pathPrefix string, opts ...DirectorOption) (*director, error) {
+	d := director{prefix: pathPrefix, resolver: p.resolver, pool: newPool()}
+	for _, opt := range opts {
+		if err := opt(&d); err != nil {
+			return nil, trail.Stacktrace(err)
+		}
+	}
+
+	if len(d.pool.upstreams) == 0 {
+		return nil, trail.NewErrorf("no upstreams for %s", pathPrefix)
+	}
+
+	for _, s := range d.splits {
+		s.pool.balancer = d.pool.balancer
+		s.pool.healthCheck = d.pool.healthCheck
+	}
+
+	d.proxy = &httputil.ReverseProxy{
+		Director:      d.direct,
+		Transport:     &d,
+		FlushInterval: d.flush,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			Send(w, r, err)
 		},
 	}
 
-	healthURL := *hostURL
-	healthURL.Path = path.Join(healthURL.Path, "/health/status")
-	p.health.AddDependency(host, healthURL.String())
+	for _, u := range d.upstreams() {
+		if d.breaker != nil {
+			u.breaker = newCircuitBreaker(*d.breaker)
+		}
+	}
+
+	return &d, nil
+}
+
+// routes gets the current route table
+func (p *Proxy) routes() map[string]*director {
+	return p.directors.Load().(map[string]*director)
+}
+
+// update atomically applies changes to a copy of the route table
+// the route table is left unchanged if the changes fail
+func (p *Proxy) update(fn func(directors map[string]*director) error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	prev := p.routes()
+	directors := make(map[string]*director, len(prev))
+	for prefix, d := range prev {
+		directors[prefix] = d
+	}
+
+	if err := fn(directors); err != nil {
+		return trail.Stacktrace(err)
+	}
+
+	p.directors.Store(directors)
+	prevUpstreams, upstreams := upstreamsByURL(prev), upstreamsByURL(directors)
+	for name := range prevUpstreams {
+		if _, present := upstreams[name]; !present {
+			p.health.RemoveDependency(name)
+			p.health.RemoveCheck("circuit:" + name)
+		}
+	}
+
+	for name, us := range upstreams {
+		if _, present := prevUpstreams[name]; !present {
+			healthURL := *us[0].URL
+			healthURL.Path = path.Join(healthURL.Path, "/health/status")
+			p.health.AddDependency(name, healthURL.String())
+		}
+
+		// circuit checks are replaced when directors for the upstream change (e.g., on reload)
+		if !sameUpstreams(prevUpstreams[name], us) {
+			p.health.RemoveCheck("circuit:" + name)
+			for _, u := range us {
+				if u.breaker != nil {
+					p.health.AddCheck("circuit:"+name, u.breaker.check)
+				}
+			}
+		}
+	}
+
 	return nil
 }
 
+// upstreamsByURL gets the upstreams of the directors by URL
+func upstreamsByURL(directors map[string]*director) map[string][]*Upstream {
+	upstreams := make(map[string][]*Upstream)
+	for _, d := range directors {
+		for _, u := range d.upstreams() {
+			upstreams[u.URL.String()] = append(upstreams[u.URL.String()], u)
+		}
+	}
+
+	return upstreams
+}
+
+// sameUpstreams checks whether the lists have the same upstreams (in any order)
+func sameUpstreams(a, b []*Upstream) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	seen := make(map[*Upstream]int, len(a))
+	for _, u := range a {
+		seen[u]++
+	}
+
+	for _, u := range b {
+		if seen[u] == 0 {
+			return false
+		}
+
+		seen[u]--
+	}
+
+	return true
+}
+
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var handler http.Handler
 	urlPath := strings.TrimPrefix(r.URL.Path, string(os.PathSeparator))
-	middlewares := []Middleware{p.cors}
+	var middlewares []Middleware
+	if p.cors != nil {
+		middlewares = append(middlewares, p.cors)
+	}
+
 	if r.URL.Path == "/health/status" {
 		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			Send(w, r, p.health.Status())
 		})
+	} else if p.admin != "" && r.URL.Path == p.admin {
+		handler = http.HandlerFunc(p.serveRoutes)
+		middlewares = append(middlewares, p.trace)
+		middlewares = append(middlewares, p.middlewares...)
 	} else {
-		var sb strings.Builder
-		for _, dir := range strings.Split(urlPath, string(os.PathSeparator)) {
-			sb.WriteString(dir)
-			if director, present := p.directors[sb.String()]; present {
-				handler = director
-				middlewares = append(middlewares, p.trace)
-				middlewares = append(middlewares, p.middlewares...)
-				break
+		var selected *director
+		var selectedRank int
+		for _, d := range p.routes() {
+			if rank, ok := d.matches(r, urlPath); ok && (selected == nil || d.precedes(rank, selected, selectedRank)) {
+				selected, selectedRank = d, rank
 			}
-			sb.WriteString("/")
+		}
+
+		if selected != nil {
+			handler = selected
+			middlewares = append(middlewares, p.trace)
+			middlewares = append(middlewares, p.middlewares...)
 		}
 	}
 
@@ -83,11 +252,15 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		handler = m.Handle(handler)
 	}
 
+	if p.resolver != nil {
+		r = r.WithContext(trail.WithIPResolver(r.Context(), p.resolver))
+	}
+
 	handler.ServeHTTP(w, r)
 }
 
 // NewProxy creates a new multi-host reverse proxy
-func NewProxy(semver string) *Proxy {
+func NewProxy(semver string, opts ...ProxyOption) *Proxy {
 	v, _ := version.NewVersion(semver)
 	cv := semver
 	if v != nil {
@@ -95,11 +268,52 @@ func NewProxy(semver string) *Proxy {
 	}
 
 	p := Proxy{
-		directors: make(map[string]*httputil.ReverseProxy),
-		cors:      NewCORSMiddleware(),
-		trace:     trail.NewTraceMiddleware(cv, false),
-		health:    health.NewService(cv),
+		files:  make(map[string][]string),
+		cors:   NewCORSMiddleware(CORSOptions{}),
+		trace:  trail.NewTraceMiddleware(cv, false),
+		health: health.NewService(cv),
+	}
+
+	p.directors.Store(make(map[string]*director))
+	for _, opt := range opts {
+		opt(&p)
 	}
 
 	return &p
 }
+
+// ProxyOption is a handler for configuring the proxy
+type ProxyOption func(p *Proxy)
+
+// WithProxyCORS creates a CORS policy option for the proxy
+func WithProxyCORS(opts CORSOptions) ProxyOption {
+	return func(p *Proxy) {
+		p.cors = NewCORSMiddleware(opts)
+	}
+}
+
+// WithoutProxyCORS creates an option disabling CORS handling by the proxy
+// (e.g., when upstream services handle CORS themselves)
+func WithoutProxyCORS() ProxyOption {
+	return func(p *Proxy) {
+		p.cors = nil
+	}
+}
+
+// WithTrustedProxies creates an option for honoring forwarding headers (e.g., X-Forwarded-For)
+// from clients within the networks (CIDRs or ips), these headers are stripped for other clients
+// the client ips of proxied requests (e.g., trail.Request.IP) are resolved with the same networks
+func WithTrustedProxies(networks ...string) ProxyOption {
+	return func(p *Proxy) {
+		p.resolver = trail.NewIPResolver(trail.WithTrustedProxies(networks...))
+	}
+}
+
+// WithRoutesEndpoint creates an option for an admin endpoint listing the current routes and their health
+// (e.g., /admin/routes), requests pass through the proxy middlewares so it can be protected (e.g., with AuthMiddleware)
+// and header match values are redacted, it should not be exposed publicly
+func WithRoutesEndpoint(endpoint string) ProxyOption {
+	return func(p *Proxy) {
+		p.admin = endpoint
+	}
+}
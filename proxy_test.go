@@ -1,10 +1,18 @@
 package tea
 
 import (
+	"bufio"
+	"context"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/pghq/go-tea/health"
 	"github.com/pghq/go-tea/trail"
 
 	"github.com/stretchr/testify/assert"
@@ -27,6 +35,21 @@ func TestProxy_ServeHTTP(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, w.Code)
 	})
 
+	t.Run("cors", func(t *testing.T) {
+		p := NewProxy("0.0.1", WithProxyCORS(CORSOptions{AllowedOrigins: []string{"https://tea.pghq.app"}}))
+		r := httptest.NewRequest("GET", "/health/status", nil)
+		r.Header.Set("Origin", "https://tea.pghq.app")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, "https://tea.pghq.app", w.Header().Get("Access-Control-Allow-Origin"))
+
+		p = NewProxy("0.0.1", WithoutProxyCORS())
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
 	t.Run("health check", func(t *testing.T) {
 		p := NewProxy("0.0.1")
 		r := httptest.NewRequest("", "/health/status", nil)
@@ -70,3 +93,521 @@ func TestProxy_ServeHTTP(t *testing.T) {
 		assert.Empty(t, w.Header().Get("Request-Trail"))
 	})
 }
+
+func TestProxy_RouteTable(t *testing.T) {
+	t.Parallel()
+
+	upstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+
+	serve := func(p *Proxy, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	t.Run("direct conflict", func(t *testing.T) {
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("test", "http://a.tld"))
+		assert.True(t, trail.IsConflict(p.Direct("test", "http://b.tld")))
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		a, b := upstream("a"), upstream("b")
+		defer a.Close()
+		defer b.Close()
+
+		p := NewProxy("")
+		assert.True(t, trail.IsNotFound(p.Replace("test", a.URL)))
+		assert.NotNil(t, p.Replace("test", ""))
+		assert.Nil(t, p.Direct("test", a.URL))
+		assert.Equal(t, "a", serve(p, "/test/foo").Body.String())
+
+		assert.Nil(t, p.Replace("test", b.URL))
+		assert.Equal(t, "b", serve(p, "/test/foo").Body.String())
+
+		status := p.health.Status()
+		assert.NotContains(t, status.Checks, a.URL)
+		assert.Contains(t, status.Checks, b.URL)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		a := upstream("a")
+		defer a.Close()
+
+		p := NewProxy("")
+		assert.True(t, trail.IsNotFound(p.Remove("test")))
+		assert.Nil(t, p.Direct("test", a.URL, WithCircuitBreaker(CircuitBreakerOptions{})))
+		assert.Contains(t, p.health.Status().Checks, "circuit:"+a.URL)
+
+		assert.Nil(t, p.Remove("test"))
+		assert.Equal(t, http.StatusNotFound, serve(p, "/test/foo").Code)
+		assert.Len(t, p.health.Status().Checks, 1)
+	})
+
+	t.Run("updates health checks", func(t *testing.T) {
+		a, b := upstream("a"), upstream("b")
+		defer a.Close()
+		defer b.Close()
+
+		p := NewProxy("")
+		breaker := WithCircuitBreaker(CircuitBreakerOptions{})
+		assert.Nil(t, p.Direct("users", a.URL, breaker))
+		assert.Nil(t, p.Direct("orders", a.URL, breaker))
+		status := p.health.Status()
+		assert.Len(t, status.Checks[a.URL], 1)
+		assert.Len(t, status.Checks["circuit:"+a.URL], 2)
+
+		assert.Nil(t, p.Replace("orders", b.URL, breaker))
+		status = p.health.Status()
+		assert.Len(t, status.Checks[a.URL], 1)
+		assert.Len(t, status.Checks["circuit:"+a.URL], 1)
+		assert.Len(t, status.Checks[b.URL], 1)
+		assert.Len(t, status.Checks["circuit:"+b.URL], 1)
+
+		assert.Nil(t, p.Remove("users"))
+		status = p.health.Status()
+		assert.NotContains(t, status.Checks, a.URL)
+		assert.NotContains(t, status.Checks, "circuit:"+a.URL)
+		assert.Len(t, status.Checks, 3)
+	})
+
+	t.Run("match", func(t *testing.T) {
+		a, b, c := upstream("a"), upstream("b"), upstream("c")
+		defer a.Close()
+		defer b.Close()
+		defer c.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("users", a.URL))
+		assert.Nil(t, p.Direct("users", b.URL, WithHostMatch("*.pghq.app")))
+		assert.Nil(t, p.Direct("users", c.URL, WithHostMatch("*.pghq.app"), WithHeaderMatch("X-Canary", "true")))
+		assert.True(t, trail.IsConflict(p.Direct("users", c.URL, WithHostMatch("*.pghq.app"))))
+
+		r := httptest.NewRequest("GET", "/users/1", nil)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, "a", w.Body.String())
+
+		r.Host = "tenant.pghq.app"
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, "b", w.Body.String())
+
+		r.Header.Set("X-Canary", "true")
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, "c", w.Body.String())
+
+		assert.True(t, trail.IsNotFound(p.Remove("users", WithHeaderMatch("X-Canary", "true"))))
+		assert.Nil(t, p.Remove("users", WithHostMatch("*.pghq.app"), WithHeaderMatch("X-Canary", "true")))
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, "b", w.Body.String())
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		a := upstream("a")
+		defer a.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("test", a.URL))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 20; i++ {
+				assert.Nil(t, p.Replace("test", a.URL))
+			}
+		}()
+
+		for i := 0; i < 20; i++ {
+			assert.Equal(t, "a", serve(p, "/test/foo").Body.String())
+		}
+
+		<-done
+	})
+}
+
+func TestProxy_Direct(t *testing.T) {
+	t.Parallel()
+
+	upstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health/status" {
+				_, _ = w.Write([]byte(`{"status": "healthy"}`))
+				return
+			}
+
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+
+	t.Run("bad upstream", func(t *testing.T) {
+		p := NewProxy("")
+		assert.NotNil(t, p.Direct("test", "http://a.tld", WithUpstreams("")))
+	})
+
+	t.Run("pool", func(t *testing.T) {
+		a, b := upstream("a"), upstream("b")
+		defer a.Close()
+		defer b.Close()
+
+		p := NewProxy("")
+		err := p.Direct("test", a.URL, WithUpstreams(b.URL), WithBalancer(RoundRobin()), WithHealthCheck(time.Minute))
+		assert.Nil(t, err)
+
+		var bodies []string
+		for i := 0; i < 4; i++ {
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest("GET", "/test/foo", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			bodies = append(bodies, w.Body.String())
+		}
+
+		assert.Equal(t, []string{"a", "b", "a", "b"}, bodies)
+		assert.Equal(t, int64(0), p.routes()["test"].pool.upstreams[0].Connections())
+	})
+
+	t.Run("bad gateway", func(t *testing.T) {
+		a := upstream("a")
+		a.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("test", a.URL))
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/test/foo", nil))
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+
+	t.Run("no healthy upstream", func(t *testing.T) {
+		a := upstream("a")
+		defer a.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("test", a.URL, WithWeightedUpstream(a.URL, 2)))
+		for _, u := range p.routes()["test"].pool.upstreams {
+			u.unhealthy = 1
+		}
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/test/foo", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+func TestProxy_Resilience(t *testing.T) {
+	t.Parallel()
+
+	t.Run("retries idempotent requests", func(t *testing.T) {
+		var calls int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			_, _ = w.Write(body)
+		}))
+		defer s.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("test", s.URL, WithRetries(RetryOptions{Attempts: 3, Backoff: time.Millisecond})))
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("PUT", "/test/foo", strings.NewReader("body")))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "body", w.Body.String())
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+		atomic.StoreInt32(&calls, 0)
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("POST", "/test/foo", strings.NewReader("body")))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("sends large bodies once", func(t *testing.T) {
+		var calls int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if atomic.AddInt32(&calls, 1) < 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+
+			_, _ = w.Write(body)
+		}))
+		defer s.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("test", s.URL, WithRetries(RetryOptions{Backoff: time.Millisecond, MaxBodySize: 16})))
+
+		large := strings.Repeat("a", 1024)
+		for _, body := range []io.Reader{strings.NewReader(large), io.MultiReader(strings.NewReader(large))} {
+			atomic.StoreInt32(&calls, 0)
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest("PUT", "/test/foo", body))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, large, w.Body.String())
+			assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		}
+
+		atomic.StoreInt32(&calls, 0)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("PUT", "/test/foo", strings.NewReader("body")))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "body", w.Body.String())
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}))
+		defer s.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("test", s.URL, WithTimeout(10*time.Millisecond)))
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/test/foo", nil))
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	})
+
+	t.Run("breaks circuit", func(t *testing.T) {
+		var calls int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer s.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("test", s.URL, WithCircuitBreaker(CircuitBreakerOptions{Failures: 2, Cooldown: time.Minute})))
+
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest("GET", "/test/foo", nil))
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+		}
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/test/foo", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+		status := p.health.Status()
+		assert.Equal(t, health.StatusUnhealthy, status.Checks["circuit:"+s.URL][0].Status)
+		assert.Equal(t, CircuitOpen, status.Checks["circuit:"+s.URL][0].Value)
+	})
+
+	t.Run("ignores cancelled clients", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				<-r.Context().Done()
+				return
+			}
+
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer s.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("test", s.URL, WithCircuitBreaker(CircuitBreakerOptions{
+			Failures: 2,
+			Cooldown: time.Minute,
+			Statuses: []int{http.StatusBadGateway},
+		})))
+
+		for i := 0; i < 2; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest("GET", "/test/slow", nil).WithContext(ctx))
+			cancel()
+		}
+
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest("GET", "/test/foo", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.NotContains(t, w.Body.String(), "circuit open")
+		}
+
+		assert.Equal(t, CircuitClosed, p.Routes()[0].Upstreams[0].Circuit)
+	})
+}
+
+func TestProxy_Rewrite(t *testing.T) {
+	t.Parallel()
+
+	backend := func(opts ...RouterOption) *httptest.Server {
+		r := NewRouter("1.0.0", opts...)
+		r.Route("GET", "/tests", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Header.Get("X-Forwarded-Prefix")))
+		})
+
+		return httptest.NewServer(r)
+	}
+
+	t.Run("with service prefix", func(t *testing.T) {
+		s := backend(WithServicePrefix("/users"))
+		defer s.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("users", s.URL))
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/users/v1/tests", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("without service prefix", func(t *testing.T) {
+		s := backend()
+		defer s.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("users", s.URL, WithStripPrefix()))
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/users/v1/tests", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "/users", w.Body.String())
+	})
+
+	t.Run("bad rewrite", func(t *testing.T) {
+		p := NewProxy("")
+		assert.NotNil(t, p.Direct("users", "http://users.tld", WithPathRewrite(`(`, "")))
+	})
+}
+
+func TestProxy_Forwarding(t *testing.T) {
+	t.Parallel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Forwarded-For")))
+	}))
+	defer s.Close()
+
+	t.Run("untrusted client", func(t *testing.T) {
+		p := NewProxy("", WithTrustedProxies("10.0.0.0/8", "bad"))
+		assert.True(t, p.resolver.Trusted(net.ParseIP("10.0.0.1")))
+		assert.Nil(t, p.Direct("test", s.URL))
+
+		r := httptest.NewRequest("GET", "/test/foo", nil)
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, "192.0.2.1", w.Body.String())
+	})
+
+	t.Run("trusted client", func(t *testing.T) {
+		p := NewProxy("", WithTrustedProxies("192.0.2.1"))
+		assert.Nil(t, p.Direct("test", s.URL))
+
+		r := httptest.NewRequest("GET", "/test/foo", nil)
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, "1.2.3.4, 192.0.2.1", w.Body.String())
+	})
+
+	t.Run("traces client ips", func(t *testing.T) {
+		var ip net.IP
+		p := NewProxy("", WithTrustedProxies("192.0.2.1"))
+		p.Middleware(MiddlewareFunc(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip = trail.RequestFromContext(r.Context()).IP()
+				next.ServeHTTP(w, r)
+			})
+		}))
+		assert.Nil(t, p.Direct("test", s.URL))
+
+		r := httptest.NewRequest("GET", "/test/foo", nil)
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+		p.ServeHTTP(httptest.NewRecorder(), r)
+		assert.Equal(t, "1.2.3.4", ip.String())
+	})
+}
+
+func TestProxy_Streaming(t *testing.T) {
+	t.Parallel()
+
+	t.Run("server-sent events", func(t *testing.T) {
+		release := make(chan struct{})
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+			<-release
+			_, _ = w.Write([]byte("data: 2\n\n"))
+		}))
+		defer s.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("events", s.URL, WithTimeout(10*time.Millisecond), WithFlushInterval(-1)))
+		ps := httptest.NewServer(p)
+		defer ps.Close()
+
+		resp, err := http.Get(ps.URL + "/events")
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+
+		b := make([]byte, 9)
+		_, err = io.ReadFull(resp.Body, b)
+		assert.Nil(t, err)
+		assert.Equal(t, "data: 1\n\n", string(b))
+
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		_, err = io.ReadFull(resp.Body, b)
+		assert.Nil(t, err)
+		assert.Equal(t, "data: 2\n\n", string(b))
+	})
+
+	t.Run("websocket upgrade", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") != "websocket" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			assert.Nil(t, err)
+			defer conn.Close()
+
+			_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+			_ = rw.Flush()
+			b := make([]byte, 4)
+			_, _ = io.ReadFull(rw, b)
+			_, _ = conn.Write(b)
+		}))
+		defer s.Close()
+
+		p := NewProxy("")
+		assert.Nil(t, p.Direct("ws", s.URL, WithTimeout(10*time.Millisecond), WithMirror(MirrorOptions{Host: s.URL})))
+		ps := httptest.NewServer(p)
+		defer ps.Close()
+
+		conn, err := net.Dial("tcp", ps.Listener.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		_, _ = conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+		time.Sleep(20 * time.Millisecond)
+		_, _ = conn.Write([]byte("ping"))
+		b := make([]byte, 4)
+		_, err = io.ReadFull(br, b)
+		assert.Nil(t, err)
+		assert.Equal(t, "ping", string(b))
+	})
+}
@@ -1,26 +1,35 @@
 package tea
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"mime/multipart"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
-	"github.com/rs/cors"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
 
 	"github.com/pghq/go-tea/trail"
 )
 
 const (
-	// maxUploadSize is the max http body size that can be sent to the app (~64 MB)
+	// maxUploadSize is the default max http body size that can be sent to the app (~64 MB)
 	maxUploadSize = 64 << 20
+
+	// maxZstdWindow is the max window size of zstd request bodies (8 MB, as recommended by RFC 8878)
+	// the decoded size is limited by the body limit
+	maxZstdWindow = 8 << 20
 )
 
 var (
@@ -43,31 +52,29 @@ func init() {
 }
 
 // Parse is a method to decode a http request into a value
-// schema struct tags are supported
+// schema struct tags are supported, as are claims tags for the claims of authenticated requests (e.g., `claims:"sub"`)
+// and auth tags for credentials (e.g., `auth:"bearer"`, `auth:"username"` or `auth:"password"`)
 func Parse(w http.ResponseWriter, r *http.Request, v interface{}) error {
 	if v == nil {
 		return trail.NewError("no value")
 	}
 
 	if r.Method != http.MethodGet && r.Body != http.NoBody {
-		b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
-		if err != nil {
-			return trail.ErrorBadRequest(err)
-		}
-
-		_ = r.Body.Close()
-		body := ioutil.NopCloser(bytes.NewBuffer(b))
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(b))
 		ct := r.Header.Get("Content-Type")
-
 		switch {
 		case strings.Contains(ct, "application/json"):
-			if err := json.NewDecoder(body).Decode(v); err != nil {
+			b, err := readBody(w, r)
+			if err != nil {
+				return err
+			}
+
+			r.Body = ioutil.NopCloser(bytes.NewBuffer(b))
+			if err := json.NewDecoder(bytes.NewReader(b)).Decode(v); err != nil {
 				return trail.ErrorBadRequest(err)
 			}
 		case strings.Contains(ct, "multipart/form-data"):
 			if err := newMultipartDecoder(w, r).decode(v); err != nil {
-				return trail.ErrorBadRequest(err)
+				return err
 			}
 		default:
 			return trail.NewErrorBadRequest("content type not supported")
@@ -86,7 +93,9 @@ func parseURL(r *http.Request, v interface{}) error {
 		return trail.NewError("no value")
 	}
 
-	newHeaderDecoder(r).decode(v)
+	if err := newHeaderDecoder(r).decode(v); err != nil {
+		return err
+	}
 
 	if err := queryDec.Decode(v, r.URL.Query()); err != nil {
 		return trail.ErrorBadRequest(err)
@@ -107,51 +116,136 @@ func parseURL(r *http.Request, v interface{}) error {
 		return trail.ErrorBadRequest(err)
 	}
 
-	return nil
+	// claims are decoded last so they cannot be overridden by the request
+	return newHeaderDecoder(r).decodeClaims(v)
 }
 
-// part reads a multipart message by name from a http request and leaves the body intact
-func part(w http.ResponseWriter, r *http.Request, name string) (*multipart.Part, error) {
-	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
+// readBody reads the request body, decoding any content encodings
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := bodyReader(w, r, bodyLimit(r))
 	if err != nil {
-		return nil, trail.ErrorBadRequest(err)
+		return nil, err
 	}
 
-	_ = r.Body.Close()
-	body := ioutil.NopCloser(bytes.NewBuffer(b))
-	defer func() {
-		r.Body = body
-		r.MultipartForm = nil
-	}()
-
-	r.Body = ioutil.NopCloser(bytes.NewBuffer(b))
-	reader, err := r.MultipartReader()
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
 	if err != nil {
-		return nil, trail.ErrorBadRequest(err)
+		return nil, readError(body, err)
+	}
+
+	r.ContentLength = int64(len(b))
+	return b, nil
+}
+
+// bodyReader creates a reader for the request body, decoding any content encodings
+// the limit applies to the decoded body and the encoding headers are removed once decoded
+func bodyReader(w http.ResponseWriter, r *http.Request, limit int64) (*limitedBody, error) {
+	var encodings []string
+	for _, value := range r.Header.Values("Content-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			if encoding = strings.ToLower(strings.TrimSpace(encoding)); encoding != "" && encoding != "identity" {
+				encodings = append(encodings, encoding)
+			}
+		}
+	}
+
+	// encodings are listed in the order they were applied
+	body := decodedBody{Reader: r.Body, closers: []io.Closer{r.Body}}
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoded, err := decoder(encodings[i], body.Reader)
+		if err != nil {
+			_ = body.Close()
+			if trail.StatusCode(err) == http.StatusUnsupportedMediaType {
+				w.Header().Set("Accept-Encoding", "gzip, deflate, zstd")
+			}
+
+			return nil, err
+		}
+
+		body.Reader = decoded
+		body.closers = append(body.closers, decoded)
+	}
+
+	if len(encodings) > 0 {
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+	}
+
+	return newLimitedBody(w, body, BodyLimitOptions{MaxSize: limit}), nil
+}
+
+// decodedBody is a request body read through content decoders
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b decodedBody) Close() error {
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if cerr := b.closers[i].Close(); err == nil {
+			err = cerr
+		}
 	}
 
-	for {
-		part, err := reader.NextPart()
+	return err
+}
+
+// decoder creates a reader decoding the content encoding
+func decoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		d, err := gzip.NewReader(r)
 		if err != nil {
 			return nil, trail.ErrorBadRequest(err)
 		}
 
-		if part.FormName() == name {
-			return part, nil
+		return d, nil
+	case "deflate":
+		// deflate is zlib wrapped, but raw deflate streams are common enough to accept
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			d, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, trail.ErrorBadRequest(err)
+			}
+
+			return d, nil
 		}
+
+		return flate.NewReader(br), nil
+	case "zstd":
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxZstdWindow))
+		if err != nil {
+			return nil, trail.ErrorBadRequest(err)
+		}
+
+		return d.IOReadCloser(), nil
 	}
+
+	return nil, trail.NewErrorWithCode(fmt.Sprintf("content encoding %s not supported", encoding), http.StatusUnsupportedMediaType)
 }
 
 // auth reads and parses the authorization header
 // from the request if provided
+// the username and password schemes get the basic auth credentials
 func auth(r *http.Request, scheme string) string {
-	auth := strings.Split(r.Header.Get("Authorization"), " ")
+	switch strings.ToLower(scheme) {
+	case "username":
+		username, _, _ := r.BasicAuth()
+		return username
+	case "password":
+		_, password, _ := r.BasicAuth()
+		return password
+	}
 
-	if len(auth) != 2 || strings.ToLower(scheme) != strings.ToLower(auth[0]) {
+	s, credentials, ok := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+	if !ok || !strings.EqualFold(scheme, s) {
 		return ""
 	}
 
-	return auth[1]
+	return strings.TrimSpace(credentials)
 }
 
 // accepts checks whether the response type is accepted
@@ -172,42 +266,12 @@ func accepts(r *http.Request, contentType string) bool {
 	return false
 }
 
-// CORSMiddleware is an implementation of the CORS middleware
-// providing method, origin, and credential allowance
-type CORSMiddleware struct{ cors *cors.Cors }
-
-// Handle provides an http handler for handling CORS
-func (m CORSMiddleware) Handle(next http.Handler) http.Handler {
-	return m.cors.Handler(next)
-}
-
-// NewCORSMiddleware constructs a new middleware that handles CORS
-func NewCORSMiddleware() CORSMiddleware {
-	return CORSMiddleware{
-		cors: cors.New(cors.Options{
-			AllowedOrigins:   nil,
-			AllowCredentials: true,
-			AllowedMethods: []string{
-				http.MethodOptions,
-				http.MethodHead,
-				http.MethodGet,
-				http.MethodPost,
-				http.MethodDelete,
-				http.MethodPatch,
-				http.MethodPut,
-			},
-			AllowedHeaders: []string{"*"},
-		}),
-	}
-}
-
-// multipartDecoder decodes multipart/form-data requests into multipart.Parts
-type multipartDecoder struct {
-	w http.ResponseWriter
+// headerDecoder decodes headers and cookies into structs
+type headerDecoder struct {
 	r *http.Request
 }
 
-func (d multipartDecoder) decode(v interface{}) error {
+func (d headerDecoder) decode(v interface{}) error {
 	rv := reflect.Indirect(reflect.ValueOf(v))
 	if rv.Kind() != reflect.Struct {
 		return nil
@@ -222,36 +286,81 @@ func (d multipartDecoder) decode(v interface{}) error {
 			continue
 		}
 
-		if key := field.Tag.Get("form"); key != "" && v.Type().Implements(reflect.TypeOf(new(io.Reader)).Elem()) {
-			part, err := part(d.w, d.r, key)
-			if err != nil {
-				return trail.ErrorBadRequest(err)
+		if field.Anonymous && v.Kind() == reflect.Struct {
+			if err := d.decode(v.Addr().Interface()); err != nil {
+				return err
 			}
+		}
 
-			v.Set(reflect.ValueOf(part))
+		if key := field.Tag.Get("auth"); key != "" && v.Type().String() == "string" {
+			v.Set(reflect.ValueOf(auth(d.r, key)))
 		}
-	}
 
-	return nil
-}
+		if key := strings.Split(field.Tag.Get("cookie"), ",")[0]; key != "" {
+			cookie, err := d.r.Cookie(key)
+			switch {
+			case err == nil && v.Type() == cookieType:
+				v.Set(reflect.ValueOf(cookie))
+			case err == nil && v.Type() == cookieType.Elem():
+				v.Set(reflect.ValueOf(*cookie))
+			case err == nil || field.Tag.Get("default") != "":
+				value := field.Tag.Get("default")
+				if cookie != nil {
+					value = cookie.Value
+				}
 
-// newMultipartDecoder creates a new multipart/form-data decoder
-func newMultipartDecoder(w http.ResponseWriter, r *http.Request) *multipartDecoder {
-	return &multipartDecoder{
-		w: w,
-		r: r,
+				parsed, err := parseHeader(value, v.Type())
+				if err != nil {
+					return trail.NewErrorBadRequest(fmt.Sprintf("bad cookie %s: %s", key, err))
+				}
+
+				v.Set(parsed)
+			}
+		}
+
+		if key := strings.Split(field.Tag.Get("header"), ",")[0]; key != "" {
+			values := d.r.Header.Values(key)
+			if def := field.Tag.Get("default"); len(values) == 0 && def != "" {
+				values = []string{def}
+			}
+
+			if len(values) == 0 {
+				continue
+			}
+
+			if v.Kind() == reflect.Slice && !reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+				slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+				for i, value := range values {
+					elem, err := parseHeader(value, v.Type().Elem())
+					if err != nil {
+						return trail.NewErrorBadRequest(fmt.Sprintf("bad header %s: %s", key, err))
+					}
+
+					slice.Index(i).Set(elem)
+				}
+
+				v.Set(slice)
+				continue
+			}
+
+			value, err := parseHeader(values[0], v.Type())
+			if err != nil {
+				return trail.NewErrorBadRequest(fmt.Sprintf("bad header %s: %s", key, err))
+			}
+
+			v.Set(value)
+		}
 	}
-}
 
-// headerDecoder decodes headers into structs
-type headerDecoder struct {
-	r *http.Request
+	return nil
 }
 
-func (d headerDecoder) decode(v interface{}) {
+// decodeClaims decodes the claims of authenticated requests into structs
+func (d headerDecoder) decodeClaims(v interface{}) error {
+	claims := ClaimsFromContext(d.r.Context())
 	rv := reflect.Indirect(reflect.ValueOf(v))
-	if rv.Kind() != reflect.Struct {
-		return
+	if claims == nil || rv.Kind() != reflect.Struct {
+		return nil
 	}
 
 	t := rv.Type()
@@ -263,29 +372,50 @@ func (d headerDecoder) decode(v interface{}) {
 			continue
 		}
 
-		if field.Anonymous {
-			rv := reflect.New(v.Type())
-			d.decode(rv.Interface())
-			v.Set(reflect.Indirect(rv))
-		}
-
-		if key := field.Tag.Get("auth"); key != "" && v.Type().String() == "string" {
-			v.Set(reflect.ValueOf(auth(d.r, key)))
+		if field.Anonymous && v.Kind() == reflect.Struct {
+			if err := d.decodeClaims(v.Addr().Interface()); err != nil {
+				return err
+			}
 		}
 
-		if key := field.Tag.Get("header"); key != "" {
-			switch v.Type().String() {
-			case "string":
-				header := d.r.Header.Get(key)
-				if header == "" {
-					header = t.Field(i).Tag.Get("default")
+		if key := field.Tag.Get("claims"); key != "" {
+			if claim, present := claims[key]; present {
+				if err := decodeClaim(claim, v); err != nil {
+					return trail.NewErrorBadRequest(fmt.Sprintf("bad claim %s: %s", key, err))
 				}
-				v.Set(reflect.ValueOf(header))
-			case "[]string":
-				v.Set(reflect.ValueOf(d.r.Header.Values(key)))
 			}
 		}
 	}
+
+	return nil
+}
+
+// decodeClaim decodes a token claim into a value
+// times are in seconds since the epoch
+func decodeClaim(claim interface{}, v reflect.Value) error {
+	if n, ok := claim.(json.Number); ok && (v.Type() == timeType || v.Type() == reflect.PtrTo(timeType)) {
+		seconds, err := n.Int64()
+		if err != nil {
+			return err
+		}
+
+		tm := time.Unix(seconds, 0)
+		if v.Kind() == reflect.Ptr {
+			v.Set(reflect.ValueOf(&tm))
+			return nil
+		}
+
+		v.Set(reflect.ValueOf(tm))
+		return nil
+	}
+
+	b, err := json.Marshal(claim)
+	if err != nil {
+		return err
+	}
+
+	v.Set(reflect.Zero(v.Type()))
+	return json.Unmarshal(b, v.Addr().Interface())
 }
 
 // newHeaderDecoder creates a new header decoder instance
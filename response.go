@@ -2,7 +2,6 @@ package tea
 
 import (
 	"encoding/json"
-	"fmt"
 	"reflect"
 	"strings"
 
@@ -12,6 +11,8 @@ import (
 )
 
 // Send sends an HTTP response based on content type and body
+// header and cookie struct tags are supported, as are values with a Cookies() []*http.Cookie method
+// errors are sent as problem details (RFC 7807) to clients accepting JSON and as plain text otherwise
 func Send(w http.ResponseWriter, r *http.Request, raw interface{}) {
 	if raw == nil {
 		w.WriteHeader(http.StatusNoContent)
@@ -23,7 +24,16 @@ func Send(w http.ResponseWriter, r *http.Request, raw interface{}) {
 		return
 	}
 
-	newHeaderEncoder(w).encode(raw)
+	if err := newHeaderEncoder(w).encode(raw); err != nil {
+		sendError(w, r, err)
+		return
+	}
+
+	if c, ok := raw.(interface{ Cookies() []*http.Cookie }); ok {
+		for _, cookie := range c.Cookies() {
+			http.SetCookie(w, cookie)
+		}
+	}
 
 	body, content, err := body(r, raw)
 	if err != nil {
@@ -54,7 +64,44 @@ func sendError(w http.ResponseWriter, r *http.Request, err error) {
 		msg = http.StatusText(status)
 	}
 
-	http.Error(w, msg, status)
+	content := problemType(r)
+	if content == "" {
+		http.Error(w, msg, status)
+		return
+	}
+
+	b, _ := json.Marshal(problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: msg})
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", content)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+// problem is an error response body for clients accepting JSON (RFC 7807)
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// problemType gets the content type of error responses for clients accepting JSON
+// errors are sent as plain text to other clients
+func problemType(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "application/problem+json"):
+		return "application/problem+json"
+	case strings.Contains(accept, "json"):
+		return "application/json"
+	}
+
+	return ""
 }
 
 // body gets the response body as bytes based on origin
@@ -87,41 +134,64 @@ type headerEncoder struct {
 	w http.ResponseWriter
 }
 
-func (e headerEncoder) encode(v interface{}) {
+func (e headerEncoder) encode(v interface{}) error {
 	rv := reflect.Indirect(reflect.ValueOf(v))
 	if rv.Kind() != reflect.Struct {
-		return
+		return nil
 	}
 
 	t := rv.Type()
 	for i := 0; i < rv.NumField(); i++ {
 		v := rv.Field(i)
-		if v.CanInterface() && v.Kind() == reflect.Struct {
-			e.encode(v.Interface())
+		if !v.CanInterface() {
 			continue
 		}
 
-		if key := t.Field(i).Tag.Get("header"); key != "" && v.CanInterface() {
-			omitempty := strings.HasSuffix(key, ",omitempty")
-			key = strings.TrimSuffix(key, ",omitempty")
+		if tag := t.Field(i).Tag.Get("cookie"); tag != "" {
+			if v.Kind() == reflect.Ptr && v.IsNil() || strings.Contains(tag, ",omitempty") && v.IsZero() {
+				continue
+			}
+
+			cookie, err := newCookie(v, tag)
+			if err != nil {
+				return trail.Stacktrace(err)
+			}
 
-			switch v.Type().String() {
-			case "string":
-				if header := v.String(); header != "" || header == "" && !omitempty {
-					e.w.Header().Set(key, header)
+			http.SetCookie(e.w, cookie)
+			continue
+		}
+
+		key := t.Field(i).Tag.Get("header")
+		if key == "" {
+			if v.Kind() == reflect.Struct {
+				if err := e.encode(v.Interface()); err != nil {
+					return err
 				}
-			case "[]string":
-				headers, _ := v.Interface().([]string)
-				for _, header := range headers {
-					if header != "" || header == "" && !omitempty {
-						e.w.Header().Add(key, header)
-					}
+			}
+
+			continue
+		}
+
+		omitempty := strings.HasSuffix(key, ",omitempty")
+		key = strings.TrimSuffix(key, ",omitempty")
+		if v.Kind() == reflect.Slice && !v.Type().Implements(textMarshalerType) {
+			for i := 0; i < v.Len(); i++ {
+				if header := formatHeader(v.Index(i)); header != "" || !omitempty {
+					e.w.Header().Add(key, header)
 				}
-			default:
-				e.w.Header().Set(key, fmt.Sprintf("%s", v.Interface()))
 			}
+
+			continue
 		}
+
+		if v.Kind() == reflect.Ptr && v.IsNil() || omitempty && v.IsZero() {
+			continue
+		}
+
+		e.w.Header().Set(key, formatHeader(v))
 	}
+
+	return nil
 }
 
 // newHeaderEncoder creates a new header decoder instance
@@ -4,10 +4,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/pghq/go-tea/trail"
 )
 
 func TestSend(t *testing.T) {
@@ -51,6 +54,23 @@ func TestSend(t *testing.T) {
 		assert.JSONEq(t, `{"key": "value"}`, w.Body.String())
 	})
 
+	t.Run("can send json errors", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/tests", nil)
+		req.Header.Set("Accept", "application/json")
+		Send(w, req, trail.NewErrorBadRequest("bad value"))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "bad value"}`, w.Body.String())
+
+		w = httptest.NewRecorder()
+		req.Header.Set("Accept", "application/problem+json")
+		Send(w, req, trail.NewError("an error has occurred"))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Internal Server Error", "status": 500, "detail": "Internal Server Error"}`, w.Body.String())
+	})
+
 	t.Run("can encode headers", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		req := httptest.NewRequest("GET", "/tests", nil)
@@ -82,6 +102,99 @@ func TestSend(t *testing.T) {
 		assert.Empty(t, w.Header().Get("empty"))
 		assert.Equal(t, response.UUID.String(), w.Header().Get("uuid"))
 	})
+
+	t.Run("can encode typed headers", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/tests", nil)
+		limit := 7
+		response := struct {
+			Count        int           `header:"X-Count"`
+			Ratio        float64       `header:"X-Ratio"`
+			Enabled      bool          `header:"X-Enabled"`
+			LastModified time.Time     `header:"Last-Modified"`
+			RetryAfter   time.Duration `header:"Retry-After"`
+			Limit        *int          `header:"X-Limit"`
+			Missing      *int          `header:"X-Missing"`
+			Zero         int           `header:"X-Zero,omitempty"`
+			Expires      time.Time     `header:"Expires,omitempty"`
+			Ids          []int         `header:"X-Ids"`
+		}{
+			Count:        3,
+			Ratio:        0.5,
+			Enabled:      true,
+			LastModified: time.Date(2015, 10, 21, 7, 28, 0, 0, time.FixedZone("EST", -5*60*60)),
+			RetryAfter:   2 * time.Minute,
+			Limit:        &limit,
+			Ids:          []int{1, 2},
+		}
+
+		Send(w, req, response)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "3", w.Header().Get("X-Count"))
+		assert.Equal(t, "0.5", w.Header().Get("X-Ratio"))
+		assert.Equal(t, "true", w.Header().Get("X-Enabled"))
+		assert.Equal(t, "Wed, 21 Oct 2015 12:28:00 GMT", w.Header().Get("Last-Modified"))
+		assert.Equal(t, "120", w.Header().Get("Retry-After"))
+		assert.Equal(t, "7", w.Header().Get("X-Limit"))
+		assert.NotContains(t, w.Header(), "X-Missing")
+		assert.NotContains(t, w.Header(), "X-Zero")
+		assert.NotContains(t, w.Header(), "Expires")
+		assert.Equal(t, []string{"1", "2"}, w.Header().Values("X-Ids"))
+	})
+
+	t.Run("can encode cookies", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/tests", nil)
+		response := struct {
+			Session string       `cookie:"session,path=/,domain=pghq.app,maxage=3600,samesite=lax,secure,httponly"`
+			Count   int          `cookie:"count,samesite=strict"`
+			Empty   string       `cookie:"empty,omitempty"`
+			Missing *http.Cookie `cookie:"missing"`
+			Remove  *http.Cookie `cookie:"remove,path=/,maxage=3600,samesite=none"`
+			Flash   http.Cookie  `cookie:"flash"`
+		}{
+			Session: "foo",
+			Count:   2,
+			Remove:  &http.Cookie{Path: "/auth", MaxAge: -1},
+			Flash:   http.Cookie{Name: "notice", Value: "saved"},
+		}
+
+		Send(w, req, response)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{
+			"session=foo; Path=/; Domain=pghq.app; Max-Age=3600; HttpOnly; Secure; SameSite=Lax",
+			"count=2; SameSite=Strict",
+			"remove=; Path=/auth; Max-Age=0; SameSite=None",
+			"notice=saved",
+		}, w.Header().Values("Set-Cookie"))
+	})
+
+	t.Run("can send cookies", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/tests", nil)
+		Send(w, req, cookieResponse{Id: "foo"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "session=foo; Path=/; HttpOnly", w.Header().Get("Set-Cookie"))
+	})
+
+	t.Run("raises cookie errors", func(t *testing.T) {
+		for _, response := range []interface{}{
+			struct {
+				Session string `cookie:"session,maxage=forever"`
+			}{},
+			struct {
+				Session string `cookie:"session,samesite=sometimes"`
+			}{},
+			struct {
+				Session string `cookie:"session,expires"`
+			}{},
+		} {
+			w := httptest.NewRecorder()
+			Send(w, httptest.NewRequest("GET", "/tests", nil), response)
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+			assert.Empty(t, w.Header().Values("Set-Cookie"))
+		}
+	})
 }
 
 func TestBody(t *testing.T) {
@@ -92,3 +205,12 @@ func TestBody(t *testing.T) {
 		assert.Nil(t, err)
 	})
 }
+
+// cookieResponse is a response setting cookies
+type cookieResponse struct {
+	Id string `json:"id"`
+}
+
+func (r cookieResponse) Cookies() []*http.Cookie {
+	return []*http.Cookie{{Name: "session", Value: r.Id, Path: "/", HttpOnly: true}}
+}
@@ -0,0 +1,114 @@
+package tea
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxReplaySize is the default max size of request bodies buffered in memory to be sent again (1 MB)
+const maxReplaySize = 1 << 20
+
+// RetryOptions is a retry policy for idempotent requests to the upstreams of a director
+type RetryOptions struct {
+	// Attempts is the maximum number of attempts per request, including the first (default 3)
+	Attempts int `yaml:"attempts"`
+
+	// Backoff is the delay before the first retry, doubling for each subsequent retry (default 50ms)
+	Backoff time.Duration `yaml:"backoff"`
+
+	// MaxBackoff is the maximum delay between retries (default 1s)
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+
+	// Budget is the ratio of retries to requests allowed within a 10s window (default 0.2)
+	// preventing retries from amplifying load on struggling upstreams
+	Budget float64 `yaml:"budget"`
+
+	// MinRetries is the number of retries allowed within a window regardless of the budget (default 10)
+	MinRetries int `yaml:"minRetries"`
+
+	// MaxBodySize is the max size of request bodies buffered for retries (default 1 MB)
+	// requests with larger bodies or bodies of unknown length are sent once without retries
+	MaxBodySize int64 `yaml:"maxBodySize"`
+}
+
+// delay gets the jittered delay before a retry attempt
+func (o RetryOptions) delay(retry int) time.Duration {
+	d := o.Backoff << (retry - 1)
+	if d <= 0 || d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryBudget limits the ratio of retries to requests
+type retryBudget struct {
+	opts     RetryOptions
+	now      func() time.Time
+	mutex    sync.Mutex
+	start    time.Time
+	requests int
+	retries  int
+}
+
+// request records a new request
+func (b *retryBudget) request() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.roll()
+	b.requests++
+}
+
+// withdraw checks whether a retry is allowed, recording it if so
+func (b *retryBudget) withdraw() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.roll()
+
+	if b.retries >= b.opts.MinRetries && float64(b.retries) >= b.opts.Budget*float64(b.requests) {
+		return false
+	}
+
+	b.retries++
+	return true
+}
+
+// roll starts a new window once the current one has elapsed
+func (b *retryBudget) roll() {
+	if now := b.now(); now.Sub(b.start) > 10*time.Second {
+		b.start = now
+		b.requests = 0
+		b.retries = 0
+	}
+}
+
+// newRetryBudget creates a new retry budget for the policy
+func newRetryBudget(opts RetryOptions) *retryBudget {
+	return &retryBudget{opts: opts, now: time.Now}
+}
+
+// idempotent checks whether requests with the method can safely be retried
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// retryable checks whether an attempt failed in a way that may succeed on retry
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,65 @@
+package tea
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryOptions_Delay(t *testing.T) {
+	t.Parallel()
+
+	t.Run("exponential with cap", func(t *testing.T) {
+		opts := RetryOptions{Backoff: 10 * time.Millisecond, MaxBackoff: 30 * time.Millisecond}
+		assert.GreaterOrEqual(t, opts.delay(1), 5*time.Millisecond)
+		assert.LessOrEqual(t, opts.delay(1), 10*time.Millisecond)
+		assert.GreaterOrEqual(t, opts.delay(2), 10*time.Millisecond)
+		assert.LessOrEqual(t, opts.delay(2), 20*time.Millisecond)
+		assert.LessOrEqual(t, opts.delay(10), 30*time.Millisecond)
+		assert.LessOrEqual(t, opts.delay(100), 30*time.Millisecond)
+	})
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Parallel()
+
+	t.Run("limits retries", func(t *testing.T) {
+		now := time.Now()
+		b := newRetryBudget(RetryOptions{Budget: 0.5, MinRetries: 1})
+		b.now = func() time.Time { return now }
+
+		b.request()
+		assert.True(t, b.withdraw())
+		assert.False(t, b.withdraw())
+
+		for i := 0; i < 4; i++ {
+			b.request()
+		}
+
+		assert.True(t, b.withdraw())
+		assert.True(t, b.withdraw())
+		assert.False(t, b.withdraw())
+
+		now = now.Add(time.Minute)
+		assert.True(t, b.withdraw())
+	})
+}
+
+func TestRetryable(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok", func(t *testing.T) {
+		assert.True(t, idempotent("GET"))
+		assert.True(t, idempotent("PUT"))
+		assert.False(t, idempotent("POST"))
+		assert.False(t, idempotent("PATCH"))
+
+		assert.True(t, retryable(nil, errors.New("an error")))
+		assert.True(t, retryable(&http.Response{StatusCode: http.StatusServiceUnavailable}, nil))
+		assert.False(t, retryable(&http.Response{StatusCode: http.StatusInternalServerError}, nil))
+		assert.False(t, retryable(&http.Response{StatusCode: http.StatusOK}, nil))
+	})
+}
@@ -2,8 +2,12 @@ package tea
 
 import (
 	"context"
-	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-version"
@@ -13,34 +17,339 @@ import (
 )
 
 // Router is an instance of a mux based Router
+// routes and middleware must be registered before the router serves requests
 type Router struct {
 	mux           *mux.Router
 	routes        *mux.Router
-	middlewares   []Middleware
+	middlewares   []routerMiddleware
 	servicePrefix string
+	version       string
+	prefix        string
+	parent        *Router
+	versions      map[string]*apiVersion
+	negotiate     bool
+	notFound      http.Handler
+	notAllowed    http.Handler
+	cors          *CORSMiddleware
+	corsRoutes    map[string]CORSMiddleware
+	documented    []documentedRoute
+	handlers      []*routeHandler
+	preRouting    map[string]http.Handler
+	preExcludes   bool
+	built         sync.Once
 }
 
 // Route adds a handler for the http method and endpoint
+// OPTIONS requests are answered by the router and HEAD requests are served
+// by GET handlers unless routes are explicitly registered for those methods.
+// Scopes and roles required by middleware (e.g., RequireScopes) are listed by Routes.
 func (r *Router) Route(method, endpoint string, handlerFunc http.HandlerFunc, middlewares ...Middleware) {
-	s := r.routes.Methods(method, "OPTIONS").Subrouter()
-	s.HandleFunc(endpoint, handlerFunc)
-	for _, m := range append(r.middlewares, middlewares...) {
-		s.Use(m.Handle)
+	var handler http.Handler = handlerFunc
+	doc := documentedRoute{method: method, template: r.path(endpoint), router: r}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if m, ok := middlewares[i].(CORSMiddleware); ok {
+			r.root().corsRoutes[r.path(endpoint)] = m
+			continue
+		}
+
+		if m, ok := middlewares[i].(RequireMiddleware); ok {
+			doc.requirements = append([]RequireMiddleware{m}, doc.requirements...)
+		}
+
+		handler = middlewares[i].Handle(handler)
 	}
+
+	r.root().documented = append(r.root().documented, doc)
+
+	if r.version != "" {
+		handler = r.root().apiVersion(r.version).Handle(handler)
+	}
+
+	rh := routeHandler{router: r, template: r.path(endpoint), handler: handler}
+	r.routes.Handle(rh.template, &rh).Methods(method)
+	r.root().handlers = append(r.root().handlers, &rh)
 }
 
 // Middleware adds a handler to execute before/after the principle request handler
+// for all routes served by the router whether they are registered before or after it
 func (r *Router) Middleware(middlewares ...Middleware) {
-	r.middlewares = append(r.middlewares, middlewares...)
+	for _, m := range middlewares {
+		r.Use(m)
+	}
+}
+
+// Use adds a handler to execute before/after the principle request handler
+// with options for the phase, priority and excluded routes
+func (r *Router) Use(m Middleware, opts ...MiddlewareOption) {
+	rm := routerMiddleware{Middleware: m}
+	for _, opt := range opts {
+		opt(&rm)
+	}
+
+	if r.parent != nil {
+		rm.phase = postRouting
+	}
+
+	r.middlewares = append(r.middlewares, rm)
+}
+
+// Group creates a sub-router for routes sharing a path prefix and middleware
+// e.g., r.Group("/orders", auth).Route("GET", "/{id}", handler)
+func (r *Router) Group(prefix string, middlewares ...Middleware) *Router {
+	g := Router{
+		mux:           r.mux,
+		routes:        r.routes,
+		servicePrefix: r.servicePrefix,
+		version:       r.version,
+		prefix:        r.prefix + strings.TrimSuffix(prefix, "/"),
+		parent:        r,
+	}
+
+	g.Middleware(middlewares...)
+	return &g
+}
+
+// Version creates a sub-router for routes served under a different API version
+// e.g., r.Version("2.0.0").Route("GET", "/tests", handler) is served at /v2/tests
+// Options (e.g., deprecation) apply to all routes served under the major version.
+func (r *Router) Version(semver string, opts ...VersionOption) *Router {
+	g := r.Group("")
+	g.version = versionPrefix(semver)
+	v := r.root().apiVersion(g.version)
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return g
+}
+
+// root gets the top level router
+func (r *Router) root() *Router {
+	if r.parent != nil {
+		return r.parent.root()
+	}
+
+	return r
+}
+
+// chain gets the middlewares in a phase for the router and all of its parents (outermost first)
+func (r *Router) chain(phase middlewarePhase) []routerMiddleware {
+	var middlewares []routerMiddleware
+	if r.parent != nil {
+		middlewares = r.parent.chain(phase)
+	}
+
+	for _, m := range r.middlewares {
+		if m.phase == phase {
+			middlewares = append(middlewares, m)
+		}
+	}
+
+	return middlewares
+}
+
+// build creates the middleware chains of the router and its routes
+// once when the router first serves a request rather than for every request
+func (r *Router) build() {
+	root := r.root()
+	middlewares := root.chain(preRouting)
+	root.preExcludes = false
+	for _, m := range middlewares {
+		root.preExcludes = root.preExcludes || len(m.except) > 0
+	}
+
+	// pre-routing chains are built per route template only if middleware excludes routes
+	dispatch := http.HandlerFunc(root.dispatch)
+	root.preRouting = map[string]http.Handler{"": pipeline(middlewares, "", dispatch)}
+	for _, h := range root.handlers {
+		if _, present := root.preRouting[h.template]; root.preExcludes && !present {
+			root.preRouting[h.template] = pipeline(middlewares, h.template, dispatch)
+		}
+
+		h.chain = pipeline(h.router.chain(postRouting), h.template, h.handler)
+	}
+}
+
+// path gets the full route path for an endpoint within the router
+func (r *Router) path(endpoint string) string {
+	return r.version + r.prefix + endpoint
+}
+
+// template gets the path template (relative to the service prefix) of the route matching the request
+func (r *Router) template(req *http.Request) string {
+	var match mux.RouteMatch
+	if !r.mux.Match(req, &match) || match.Route == nil {
+		if req.Method != http.MethodHead {
+			return ""
+		}
+
+		get := req.Clone(req.Context())
+		get.Method = http.MethodGet
+		if !r.mux.Match(get, &match) || match.Route == nil {
+			return ""
+		}
+	}
+
+	template, _ := match.Route.GetPathTemplate()
+	return strings.TrimPrefix(template, r.servicePrefix)
 }
 
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	root := r.root()
+	root.built.Do(root.build)
+	if root.negotiate {
+		req = root.negotiateVersion(w, req)
+	}
+
+	handler := root.preRouting[""]
+	if root.preExcludes {
+		if h, present := root.preRouting[root.template(req)]; present {
+			handler = h
+		}
+	}
+
+	handler.ServeHTTP(w, req)
+}
+
+// dispatch routes the request, answering OPTIONS requests and serving HEAD requests
+// from GET handlers for paths without routes explicitly registered for those methods
+func (r *Router) dispatch(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodOptions || req.Method == http.MethodHead {
+		var match mux.RouteMatch
+		if r.mux.Match(req, &match) && match.MatchErr == nil {
+			r.mux.ServeHTTP(w, req)
+			return
+		}
+
+		if req.Method == http.MethodOptions {
+			if allow := r.allow(req); len(allow) > 0 {
+				w.Header().Set("Allow", strings.Join(allow, ", "))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
+		get := req.Clone(req.Context())
+		get.Method = http.MethodGet
+		if req.Method == http.MethodHead && r.mux.Match(get, &match) && match.MatchErr == nil {
+			hw := headResponseWriter{ResponseWriter: w}
+			match.Handler.ServeHTTP(&hw, mux.SetURLVars(req, match.Vars))
+			hw.flush()
+			return
+		}
+	}
+
 	r.mux.ServeHTTP(w, req)
 }
 
+// allowed gets the methods registered for the request path
+func (r *Router) allowed(req *http.Request) []string {
+	var methods []string
+	seen := make(map[string]struct{})
+	_ = r.mux.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		routeMethods, _ := route.GetMethods()
+		for _, method := range routeMethods {
+			if _, present := seen[method]; present {
+				continue
+			}
+
+			var match mux.RouteMatch
+			candidate := req.Clone(req.Context())
+			candidate.Method = method
+			if route.Match(candidate, &match) {
+				seen[method] = struct{}{}
+				methods = append(methods, method)
+			}
+		}
+
+		return nil
+	})
+
+	return methods
+}
+
+// allow gets the methods allowed for the request path
+// including the implicit HEAD (for GET routes) and OPTIONS methods
+func (r *Router) allow(req *http.Request) []string {
+	methods := r.allowed(req)
+	if len(methods) == 0 {
+		return nil
+	}
+
+	implicit := []string{http.MethodOptions}
+	for _, method := range methods {
+		if method == http.MethodGet {
+			implicit = append(implicit, http.MethodHead)
+		}
+	}
+
+	for _, method := range implicit {
+		var present bool
+		for _, m := range methods {
+			present = present || m == method
+		}
+
+		if !present {
+			methods = append(methods, method)
+		}
+	}
+
+	sort.Strings(methods)
+	return methods
+}
+
+// headResponseWriter discards the response body of HEAD requests served by GET handlers
+// while preserving the Content-Length the GET response would have had
+type headResponseWriter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+func (w *headResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *headResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.length += len(b)
+	return len(b), nil
+}
+
+// flush writes the buffered status and Content-Length
+func (w *headResponseWriter) flush() {
+	w.WriteHeader(http.StatusOK)
+	if w.length > 0 && w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(w.length))
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// routeHandler is a http handler for a route which applies the router middleware
+type routeHandler struct {
+	router   *Router
+	template string
+	handler  http.Handler
+	chain    http.Handler
+}
+
+func (h *routeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.chain.ServeHTTP(w, r)
+}
+
 // NewRouter constructs a new mux based Router
 func NewRouter(semver string, opts ...RouterOption) *Router {
-	r := Router{mux: mux.NewRouter().StrictSlash(true)}
+	r := Router{
+		mux:        mux.NewRouter().StrictSlash(true),
+		versions:   make(map[string]*apiVersion),
+		corsRoutes: make(map[string]CORSMiddleware),
+		notFound:   http.HandlerFunc(NotFoundHandler),
+		notAllowed: http.HandlerFunc(MethodNotAllowedHandler),
+	}
+
 	for _, opt := range opts {
 		opt(&r)
 	}
@@ -56,12 +365,15 @@ func NewRouter(semver string, opts ...RouterOption) *Router {
 	})
 
 	v, _ := version.NewVersion(semver)
-	if v != nil {
-		r.routes = r.routes.PathPrefix(fmt.Sprintf("/v%d", v.Segments()[0])).Subrouter()
-	}
-	r.mux.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
-	r.mux.MethodNotAllowedHandler = http.HandlerFunc(MethodNotAllowedHandler)
-	r.Middleware(MiddlewareFunc(trail.NewTraceMiddleware(v.String(), true)))
+	r.version = versionPrefix(semver)
+	r.mux.NotFoundHandler = r.notFound
+	r.mux.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Allow", strings.Join(r.allow(req), ", "))
+		r.notAllowed.ServeHTTP(w, req)
+	})
+
+	r.Use(MiddlewareFunc(trail.NewTraceMiddleware(v.String(), true)), PreRouting(), WithPriority(math.MaxInt))
+	r.Use(MiddlewareFunc(r.handleCORS), PreRouting(), WithPriority(math.MaxInt-1))
 	return &r
 }
 
@@ -75,16 +387,29 @@ func WithServicePrefix(prefix string) RouterOption {
 	}
 }
 
-// NotFoundHandler is a custom handler for not found requests
-func NotFoundHandler(w http.ResponseWriter, _ *http.Request) {
-	w.WriteHeader(http.StatusNotFound)
-	_, _ = w.Write([]byte(http.StatusText(http.StatusNotFound)))
+// WithNotFoundHandler creates a custom not found handler option
+func WithNotFoundHandler(h http.Handler) RouterOption {
+	return func(r *Router) {
+		r.notFound = h
+	}
+}
+
+// WithMethodNotAllowedHandler creates a custom method not allowed handler option
+// the Allow header is populated with the methods registered for the path before it is called
+func WithMethodNotAllowedHandler(h http.Handler) RouterOption {
+	return func(r *Router) {
+		r.notAllowed = h
+	}
+}
+
+// NotFoundHandler is the default handler for not found requests
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	Send(w, r, trail.NewErrorNotFound(http.StatusText(http.StatusNotFound)))
 }
 
-// MethodNotAllowedHandler is a custom handler for method not allowed requests
-func MethodNotAllowedHandler(w http.ResponseWriter, _ *http.Request) {
-	w.WriteHeader(http.StatusMethodNotAllowed)
-	_, _ = w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+// MethodNotAllowedHandler is the default handler for method not allowed requests
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	Send(w, r, trail.NewErrorWithCode(http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed))
 }
 
 // HTTPCommand creates a http command handler from a command
@@ -11,6 +11,7 @@ import (
 
 	"github.com/pghq/go-tea/trail"
 
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -97,6 +98,92 @@ func TestRouter_Route(t *testing.T) {
 	})
 }
 
+func TestRouter_Options(t *testing.T) {
+	t.Parallel()
+
+	t.Run("answered by router", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Route("GET", "/tests", func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("handler called for options request")
+		})
+		r.Route("POST", "/tests", func(w http.ResponseWriter, r *http.Request) {})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/v0/tests", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "GET, HEAD, OPTIONS, POST", w.Header().Get("Allow"))
+	})
+
+	t.Run("explicit route", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Route("GET", "/tests", func(w http.ResponseWriter, r *http.Request) {})
+		r.Route("OPTIONS", "/tests", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/v0/tests", nil))
+		assert.Equal(t, http.StatusTeapot, w.Code)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		r := NewRouter("0")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/v0/tests", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestRouter_Head(t *testing.T) {
+	t.Parallel()
+
+	t.Run("served by get handler", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Route("GET", "/tests/{id}", func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "HEAD", r.Method)
+			assert.Equal(t, "1", mux.Vars(r)["id"])
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("hello"))
+			_, _ = w.Write([]byte(" world"))
+		})
+
+		s := httptest.NewServer(r)
+		defer s.Close()
+
+		resp, err := s.Client().Head(s.URL + "/v0/tests/1")
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int64(11), resp.ContentLength)
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		assert.NotEmpty(t, resp.Header.Get("Request-Id"))
+		assert.Empty(t, body)
+	})
+
+	t.Run("explicit route", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Route("GET", "/tests", func(w http.ResponseWriter, r *http.Request) {})
+		r.Route("HEAD", "/tests", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("HEAD", "/v0/tests", nil))
+		assert.Equal(t, http.StatusTeapot, w.Code)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Route("POST", "/tests", func(w http.ResponseWriter, r *http.Request) {})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("HEAD", "/v0/tests", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "OPTIONS, POST", w.Header().Get("Allow"))
+	})
+}
+
 func TestRouter_Middleware(t *testing.T) {
 	t.Parallel()
 	t.Run("processes handler", func(t *testing.T) {
@@ -116,6 +203,186 @@ func TestRouter_Middleware(t *testing.T) {
 	})
 }
 
+func TestRouter_Use(t *testing.T) {
+	t.Parallel()
+
+	header := func(key, value string) Middleware {
+		return MiddlewareFunc(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add(key, value)
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	t.Run("late registration", func(t *testing.T) {
+		r := NewRouter("0")
+		g := r.Group("/tests")
+		g.Route("GET", "", ok)
+		r.Middleware(header("Test", "router"))
+		g.Middleware(header("Test", "group"))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v0/tests", nil))
+		assert.Equal(t, []string{"router", "group"}, w.Header().Values("Test"))
+	})
+
+	t.Run("builds chains once", func(t *testing.T) {
+		var builds int
+		counted := func(m Middleware) Middleware {
+			return MiddlewareFunc(func(next http.Handler) http.Handler {
+				builds++
+				return m.Handle(next)
+			})
+		}
+
+		r := NewRouter("0")
+		r.Route("GET", "/tests", ok)
+		r.Use(counted(header("Test", "pre")), PreRouting(), Except("/health/status"))
+		r.Use(counted(header("Test", "post")))
+		r.Route("GET", "/others", ok)
+		assert.Equal(t, 0, builds)
+
+		var built int
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/v0/tests", nil))
+			assert.Equal(t, []string{"pre", "post"}, w.Header().Values("Test"))
+			if i == 0 {
+				built = builds
+			}
+		}
+
+		assert.NotZero(t, built)
+		assert.Equal(t, built, builds)
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Route("GET", "/tests", ok)
+		r.Use(header("Test", "low"), WithPriority(-1))
+		r.Use(header("Test", "default"))
+		r.Use(header("Test", "high"), WithPriority(1))
+		r.Use(header("Test", "default 2"))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v0/tests", nil))
+		assert.Equal(t, []string{"high", "default", "default 2", "low"}, w.Header().Values("Test"))
+	})
+
+	t.Run("pre routing", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Route("GET", "/tests", ok)
+		r.Use(header("Test", "post"))
+		r.Use(header("Test", "pre"), PreRouting())
+		r.Group("/group").Use(header("Test", "group"), PreRouting())
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v0/tests", nil))
+		assert.Equal(t, []string{"pre", "post"}, w.Header().Values("Test"))
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v0/missing", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, []string{"pre"}, w.Header().Values("Test"))
+	})
+
+	t.Run("except", func(t *testing.T) {
+		r := NewRouter("0", WithServicePrefix("/service"))
+		r.Route("GET", "/tests/{id}", ok)
+		r.Use(header("Test", "post"), Except("/health/status"))
+		r.Use(header("Test", "pre"), PreRouting(), Except("/v0/tests/*"))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/service/health/status", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"pre"}, w.Header().Values("Test"))
+		assert.NotEmpty(t, w.Header().Get("Request-Id"))
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/service/v0/tests/1", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"post"}, w.Header().Values("Test"))
+	})
+}
+
+func TestRouter_Group(t *testing.T) {
+	t.Parallel()
+
+	header := func(key, value string) Middleware {
+		return MiddlewareFunc(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add(key, value)
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}
+
+	t.Run("prefix", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Group("/orders/").Route("GET", "/{id}", ok)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v0/orders/1", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "/v0/orders/1", w.Body.String())
+	})
+
+	t.Run("nested with scoped middleware", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Middleware(header("Test", "router"))
+		g := r.Group("/orders", header("Test", "orders"))
+		g.Group("/{id}/items", header("Test", "items")).Route("GET", "", ok, header("Test", "route"))
+		g.Route("GET", "", ok)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v0/orders/1/items", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"router", "orders", "items", "route"}, w.Header().Values("Test"))
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v0/orders", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"router", "orders"}, w.Header().Values("Test"))
+	})
+
+	t.Run("version override", func(t *testing.T) {
+		r := NewRouter("1.0.0")
+		g := r.Group("/orders")
+		g.Route("GET", "", ok)
+		g.Version("2.0.0").Route("GET", "", ok)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/orders", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v2/orders", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("http query", func(t *testing.T) {
+		r := NewRouter("0", WithServicePrefix("/service"))
+		type query struct {
+			Id string `path:"id"`
+		}
+
+		h := func(ctx context.Context, query query) (string, error) { return query.Id, nil }
+		r.Group("/tests").Route("GET", "/{id}", HTTPQuery(h))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/service/v0/tests/foo", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "foo", w.Body.String())
+	})
+}
+
 func TestNotFoundHandler(t *testing.T) {
 	t.Parallel()
 	t.Run("sends response", func(t *testing.T) {
@@ -124,7 +391,18 @@ func TestNotFoundHandler(t *testing.T) {
 
 		NotFoundHandler(w, r)
 		assert.Equal(t, http.StatusNotFound, w.Code)
-		assert.Equal(t, http.StatusText(http.StatusNotFound), w.Body.String())
+		assert.Equal(t, http.StatusText(http.StatusNotFound)+"\n", w.Body.String())
+	})
+
+	t.Run("sends json", func(t *testing.T) {
+		r := NewRouter("0")
+		req := httptest.NewRequest("GET", "/v0/tests", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Not Found", "status": 404, "detail": "Not Found"}`, w.Body.String())
 	})
 
 	t.Run("routes not found", func(t *testing.T) {
@@ -149,6 +427,25 @@ func TestNotFoundHandler(t *testing.T) {
 
 		RequestTest(t, r, req)
 	})
+
+	t.Run("traced", func(t *testing.T) {
+		r := NewRouter("0")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v0/tests", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Request-Id"))
+	})
+
+	t.Run("custom handler", func(t *testing.T) {
+		r := NewRouter("0", WithNotFoundHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Send(w, r, trail.NewErrorNotFound("custom"))
+		})))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v0/tests", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "custom\n", w.Body.String())
+		assert.NotEmpty(t, w.Header().Get("Request-Id"))
+	})
 }
 
 func TestMethodNotAllowedHandler(t *testing.T) {
@@ -159,7 +456,20 @@ func TestMethodNotAllowedHandler(t *testing.T) {
 
 		MethodNotAllowedHandler(w, r)
 		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
-		assert.Equal(t, http.StatusText(http.StatusMethodNotAllowed), w.Body.String())
+		assert.Equal(t, http.StatusText(http.StatusMethodNotAllowed)+"\n", w.Body.String())
+	})
+
+	t.Run("sends json", func(t *testing.T) {
+		r := NewRouter("0")
+		r.Route("GET", "/tests", func(w http.ResponseWriter, r *http.Request) {})
+		req := httptest.NewRequest("POST", "/v0/tests", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"type": "about:blank", "title": "Method Not Allowed", "status": 405, "detail": "Method Not Allowed"}`, w.Body.String())
 	})
 
 	t.Run("routes method not allowed", func(t *testing.T) {
@@ -174,6 +484,32 @@ func TestMethodNotAllowedHandler(t *testing.T) {
 
 		RequestTest(t, r, req)
 	})
+
+	t.Run("allow header", func(t *testing.T) {
+		ok := func(w http.ResponseWriter, r *http.Request) {}
+		r := NewRouter("0")
+		r.Route("GET", "/tests/{id}", ok)
+		r.Route("PUT", "/tests/{id}", ok)
+		r.Route("POST", "/tests", ok)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("DELETE", "/v0/tests/1", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "GET, HEAD, OPTIONS, PUT", w.Header().Get("Allow"))
+		assert.NotEmpty(t, w.Header().Get("Request-Id"))
+	})
+
+	t.Run("custom handler", func(t *testing.T) {
+		r := NewRouter("0", WithMethodNotAllowedHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+			w.WriteHeader(http.StatusTeapot)
+		})))
+		r.Route("GET", "/tests", func(w http.ResponseWriter, r *http.Request) {})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/v0/tests", nil))
+		assert.Equal(t, http.StatusTeapot, w.Code)
+	})
 }
 
 func TestHTTPCommand(t *testing.T) {
@@ -0,0 +1,420 @@
+package tea
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// ProxyRoutes is a set of proxy routes loaded from a YAML or JSON file
+type ProxyRoutes struct {
+	Routes []ProxyRoute `yaml:"routes"`
+}
+
+// ProxyRoute is the configuration of a director loaded from a routes file
+type ProxyRoute struct {
+	// Prefix is the path prefix directed to the upstreams (e.g., the name of the microservice)
+	Prefix string `yaml:"prefix"`
+
+	// Hosts, Headers, Query and Methods are further conditions for directing requests (see WithHostMatch)
+	Hosts   []string          `yaml:"hosts"`
+	Headers map[string]string `yaml:"headers"`
+	Query   map[string]string `yaml:"query"`
+	Methods []string          `yaml:"methods"`
+
+	// Upstreams is the pool of upstream hosts
+	Upstreams []ProxyUpstream `yaml:"upstreams"`
+
+	// Balancer is the strategy balancing requests across the upstreams
+	// (roundRobin, leastConnections, weighted or consistentHash, defaults to roundRobin)
+	Balancer string `yaml:"balancer"`
+
+	// HashKey is the key assigning requests to upstreams for consistentHash (userId, cookie:<name> or header:<name>)
+	HashKey string `yaml:"hashKey"`
+
+	HealthCheck    time.Duration          `yaml:"healthCheck"`
+	Timeout        time.Duration          `yaml:"timeout"`
+	Retries        *RetryOptions          `yaml:"retries"`
+	CircuitBreaker *CircuitBreakerOptions `yaml:"circuitBreaker"`
+	StripPrefix    bool                   `yaml:"stripPrefix"`
+	AddPrefix      string                 `yaml:"addPrefix"`
+	Rewrites       []ProxyRewrite         `yaml:"rewrites"`
+	HostHeader     string                 `yaml:"hostHeader"`
+	FlushInterval  time.Duration          `yaml:"flushInterval"`
+
+	// Splits sends percentages of requests to other versions of the upstreams (see WithSplit)
+	Splits []ProxySplit `yaml:"splits"`
+
+	// Sticky is the key consistently assigning requests to versions (userId, cookie:<name> or header:<name>)
+	Sticky string `yaml:"sticky"`
+
+	// Override is the request header forcing the version
+	Override string `yaml:"override"`
+
+	Mirror *MirrorOptions `yaml:"mirror"`
+}
+
+// ProxySplit is a version of the upstreams of a proxy route
+type ProxySplit struct {
+	Version   string   `yaml:"version"`
+	Percent   int      `yaml:"percent"`
+	Upstreams []string `yaml:"upstreams"`
+}
+
+// ProxyUpstream is an upstream host of a proxy route
+type ProxyUpstream struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+// ProxyRewrite is a path rewrite of a proxy route
+type ProxyRewrite struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// options gets the director options for the route
+func (r ProxyRoute) options() ([]DirectorOption, error) {
+	var opts []DirectorOption
+	for _, u := range r.Upstreams {
+		opts = append(opts, WithWeightedUpstream(u.URL, u.Weight))
+	}
+
+	if len(r.Hosts) > 0 {
+		opts = append(opts, WithHostMatch(r.Hosts...))
+	}
+
+	for name, value := range r.Headers {
+		opts = append(opts, WithHeaderMatch(name, value))
+	}
+
+	for key, value := range r.Query {
+		opts = append(opts, WithQueryMatch(key, value))
+	}
+
+	if len(r.Methods) > 0 {
+		opts = append(opts, WithMethodMatch(r.Methods...))
+	}
+
+	switch r.Balancer {
+	case "", "roundRobin":
+	case "leastConnections":
+		opts = append(opts, WithBalancer(LeastConnections()))
+	case "weighted":
+		opts = append(opts, WithBalancer(Weighted()))
+	case "consistentHash":
+		key, ok := hashKey(r.HashKey)
+		if !ok {
+			return nil, trail.NewErrorf("unknown hash key %s for %s", r.HashKey, r.Prefix)
+		}
+
+		opts = append(opts, WithBalancer(ConsistentHash(key)))
+	default:
+		return nil, trail.NewErrorf("unknown balancer %s for %s", r.Balancer, r.Prefix)
+	}
+
+	if r.HealthCheck > 0 {
+		opts = append(opts, WithHealthCheck(r.HealthCheck))
+	}
+
+	if r.Timeout > 0 {
+		opts = append(opts, WithTimeout(r.Timeout))
+	}
+
+	if r.Retries != nil {
+		opts = append(opts, WithRetries(*r.Retries))
+	}
+
+	if r.CircuitBreaker != nil {
+		opts = append(opts, WithCircuitBreaker(*r.CircuitBreaker))
+	}
+
+	if r.StripPrefix {
+		opts = append(opts, WithStripPrefix())
+	}
+
+	for _, rewrite := range r.Rewrites {
+		opts = append(opts, WithPathRewrite(rewrite.Pattern, rewrite.Replacement))
+	}
+
+	if r.AddPrefix != "" {
+		opts = append(opts, WithAddPrefix(r.AddPrefix))
+	}
+
+	if r.HostHeader != "" {
+		opts = append(opts, WithHostHeader(r.HostHeader))
+	}
+
+	if r.FlushInterval != 0 {
+		opts = append(opts, WithFlushInterval(r.FlushInterval))
+	}
+
+	for _, s := range r.Splits {
+		opts = append(opts, WithSplit(s.Version, s.Percent, s.Upstreams...))
+	}
+
+	if r.Sticky != "" {
+		key, ok := hashKey(r.Sticky)
+		if !ok {
+			return nil, trail.NewErrorf("unknown sticky key %s for %s", r.Sticky, r.Prefix)
+		}
+
+		opts = append(opts, WithStickySplit(key))
+	}
+
+	if r.Override != "" {
+		opts = append(opts, WithSplitOverride(r.Override))
+	}
+
+	if r.Mirror != nil {
+		opts = append(opts, WithMirror(*r.Mirror))
+	}
+
+	return opts, nil
+}
+
+// hashKey gets the hash key for a name (userId, cookie:<name> or header:<name>)
+func hashKey(name string) (HashKey, bool) {
+	switch kind, value, _ := strings.Cut(name, ":"); {
+	case name == "userId":
+		return HashByUserId(), true
+	case kind == "cookie" && value != "":
+		return HashByCookie(value), true
+	case kind == "header" && value != "":
+		return HashByHeader(value), true
+	}
+
+	return nil, false
+}
+
+// LoadRoutes atomically replaces the routes previously loaded from the YAML or JSON file
+// the current routes are left unchanged if any route in the file is invalid
+func (p *Proxy) LoadRoutes(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return trail.Stacktrace(err)
+	}
+
+	var config ProxyRoutes
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return trail.Stacktrace(err)
+	}
+
+	loaded := make(map[string]*director)
+	for _, route := range config.Routes {
+		opts, err := route.options()
+		if err != nil {
+			return trail.Stacktrace(err)
+		}
+
+		d, err := p.director(route.Prefix, opts...)
+		if err != nil {
+			return trail.Stacktrace(err)
+		}
+
+		if _, present := loaded[d.key()]; present {
+			return trail.NewErrorf("duplicate route %s in %s", d.key(), filename)
+		}
+
+		loaded[d.key()] = d
+	}
+
+	return p.update(func(directors map[string]*director) error {
+		for _, key := range p.files[filename] {
+			delete(directors, key)
+		}
+
+		var keys []string
+		for key, d := range loaded {
+			if _, present := directors[key]; present {
+				return trail.NewErrorConflict("director already exists for " + key)
+			}
+
+			directors[key] = d
+			keys = append(keys, key)
+		}
+
+		p.files[filename] = keys
+		return nil
+	})
+}
+
+// WatchRoutes loads the routes from the YAML or JSON file and reloads them
+// whenever the file changes (checked once per interval) until the context is done
+func (p *Proxy) WatchRoutes(ctx context.Context, filename string, interval time.Duration) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return trail.Stacktrace(err)
+	}
+
+	if err := p.LoadRoutes(filename); err != nil {
+		return trail.Stacktrace(err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			latest, err := os.Stat(filename)
+			if err != nil {
+				trail.Warnf("tea.proxy: watching routes %s: %s", filename, err)
+				continue
+			}
+
+			if latest.ModTime().Equal(info.ModTime()) && latest.Size() == info.Size() {
+				continue
+			}
+
+			info = latest
+			if err := p.LoadRoutes(filename); err != nil {
+				trail.Errorf("tea.proxy: reloading routes %s: %s", filename, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// ProxyRouteStatus is the current state of a proxy route
+type ProxyRouteStatus struct {
+	Prefix    string                `json:"prefix"`
+	Hosts     []string              `json:"hosts,omitempty"`
+	Headers   map[string]string     `json:"headers,omitempty"`
+	Query     map[string]string     `json:"query,omitempty"`
+	Methods   []string              `json:"methods,omitempty"`
+	Upstreams []ProxyUpstreamStatus `json:"upstreams"`
+	Splits    map[string]int        `json:"splits,omitempty"`
+	Mirror    string                `json:"mirror,omitempty"`
+
+	key string
+}
+
+// ProxyUpstreamStatus is the current state of an upstream of a proxy route
+type ProxyUpstreamStatus struct {
+	URL         string       `json:"url"`
+	Version     string       `json:"version,omitempty"`
+	Weight      int          `json:"weight"`
+	Healthy     bool         `json:"healthy"`
+	Connections int64        `json:"connections"`
+	Circuit     CircuitState `json:"circuit,omitempty"`
+}
+
+// Routes gets the current state of the proxy routes (ordered by prefix and match conditions)
+// passwords in upstream URLs are redacted
+func (p *Proxy) Routes() []ProxyRouteStatus {
+	var routes []ProxyRouteStatus
+	for key, d := range p.routes() {
+		// the match conditions are copied so callers cannot change how the route is matched
+		route := ProxyRouteStatus{
+			Prefix:  d.prefix,
+			Hosts:   append([]string(nil), d.match.hosts...),
+			Headers: cloneValues(d.match.headers),
+			Query:   cloneValues(d.match.query),
+			Methods: append([]string(nil), d.match.methods...),
+			key:     key,
+		}
+
+		route.Upstreams = append(route.Upstreams, upstreamStatus(d.pool, "")...)
+		for _, s := range d.splits {
+			if route.Splits == nil {
+				route.Splits = map[string]int{StableVersion: 100}
+				for i := range route.Upstreams {
+					route.Upstreams[i].Version = StableVersion
+				}
+			}
+
+			route.Splits[s.version] = s.percent
+			route.Splits[StableVersion] -= s.percent
+			route.Upstreams = append(route.Upstreams, upstreamStatus(s.pool, s.version)...)
+		}
+
+		if d.shadow != nil {
+			route.Mirror = d.shadow.url.Redacted()
+		}
+
+		routes = append(routes, route)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].key < routes[j].key
+	})
+
+	return routes
+}
+
+// cloneValues copies a map of match conditions
+func cloneValues(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+
+	clone := make(map[string]string, len(values))
+	for key, value := range values {
+		clone[key] = value
+	}
+
+	return clone
+}
+
+// upstreamStatus gets the current state of the upstreams in the pool
+func upstreamStatus(p *pool, version string) []ProxyUpstreamStatus {
+	var upstreams []ProxyUpstreamStatus
+	for _, u := range p.upstreams {
+		upstream := ProxyUpstreamStatus{
+			URL:         u.URL.Redacted(),
+			Version:     version,
+			Weight:      u.Weight,
+			Healthy:     u.Healthy(),
+			Connections: u.Connections(),
+		}
+
+		if u.breaker != nil {
+			upstream.Circuit = u.breaker.State()
+		}
+
+		upstreams = append(upstreams, upstream)
+	}
+
+	return upstreams
+}
+
+// serveRoutes is the admin endpoint listing the current proxy routes
+func (p *Proxy) serveRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		Send(w, r, trail.NewErrorWithCode(http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed))
+		return
+	}
+
+	routes := p.Routes()
+	for i, route := range routes {
+		if len(route.Headers) > 0 {
+			headers := make(map[string]string, len(route.Headers))
+			for key := range route.Headers {
+				headers[key] = "[redacted]"
+			}
+
+			routes[i].Headers = headers
+		}
+	}
+
+	Send(w, r, routes)
+}
@@ -0,0 +1,538 @@
+package tea
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// SessionOptions is a policy for cookie sessions
+type SessionOptions struct {
+	// Name is the name of the session cookie (default session)
+	Name string
+
+	// Keys are the secrets for signing and encrypting sessions
+	// the first key is used for new sessions and the rest are accepted until sessions are rotated to the first key
+	// (a random key is generated if empty, so sessions do not survive restarts)
+	Keys [][]byte
+
+	// Encrypt encrypts the session data stored in the cookie with AES-GCM
+	Encrypt bool
+
+	// Store is a server-side store for session data, the cookie only holds the signed session id if present
+	Store SessionStore
+
+	// MaxAge is how long sessions last since they were last saved (default 24h)
+	MaxAge time.Duration
+
+	// Path is the path of the session cookie (default /)
+	Path string
+
+	// Domain is the domain of the session cookie
+	Domain string
+
+	// Secure restricts the session cookie to HTTPS
+	Secure bool
+
+	// SameSite is the same site policy of the session cookie (default lax)
+	// the cookie is always HTTP only
+	SameSite http.SameSite
+}
+
+// SessionStore is a server-side store for session data
+type SessionStore interface {
+	// Load gets the session data by id, returning a not found error if missing or expired
+	Load(ctx context.Context, id string) ([]byte, error)
+
+	// Save stores the session data by id for the ttl
+	Save(ctx context.Context, id string, data []byte, ttl time.Duration) error
+
+	// Delete removes the session data by id
+	Delete(ctx context.Context, id string) error
+}
+
+// SessionMiddleware is an implementation of the session middleware
+// exposing a Session through the request context
+type SessionMiddleware struct {
+	opts SessionOptions
+	keys []sessionKey
+}
+
+// Handle provides an http handler for loading and saving sessions
+func (m SessionMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.load(r)
+		if req := trail.RequestFromContext(r.Context()); req != nil {
+			s.request = req
+			if s.data.UserId != nil {
+				req.SetUserId(*s.data.UserId)
+			}
+		}
+
+		sw := sessionWriter{ResponseWriter: w, m: m, s: s, ctx: r.Context()}
+		defer sw.save()
+		next.ServeHTTP(&sw, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, s)))
+	})
+}
+
+// load gets the session for the request, or a new session if missing or invalid
+func (m SessionMiddleware) load(r *http.Request) *Session {
+	s := Session{}
+	cookie, err := r.Cookie(m.opts.Name)
+	if err != nil {
+		return &s
+	}
+
+	payload, primary, ok := m.open(cookie.Value)
+	if !ok {
+		return &s
+	}
+
+	if m.opts.Store != nil {
+		id := string(payload)
+		if payload, err = m.opts.Store.Load(r.Context(), id); err != nil {
+			if !trail.IsNotFound(err) {
+				trail.Errorf("session %s could not be loaded: %+v", m.opts.Name, err)
+			}
+
+			return &s
+		}
+
+		s.id = id
+	}
+
+	var data sessionData
+	if err := json.Unmarshal(payload, &data); err != nil || time.Now().Unix() >= data.Expires {
+		return &Session{}
+	}
+
+	s.data = data
+	s.modified = !primary
+	return &s
+}
+
+// seal signs (and optionally encrypts) the payload with the primary key
+func (m SessionMiddleware) seal(payload []byte) ([]byte, error) {
+	key := m.keys[0]
+	if m.opts.Encrypt {
+		nonce := make([]byte, key.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, trail.Stacktrace(err)
+		}
+
+		payload = key.aead.Seal(nonce, nonce, payload, []byte(m.opts.Name))
+	}
+
+	encoding := base64.RawURLEncoding
+	body := encoding.EncodeToString(payload)
+	return []byte(body + "." + encoding.EncodeToString(key.sign(m.opts.Name, body))), nil
+}
+
+// open verifies (and optionally decrypts) the cookie value with any of the keys
+func (m SessionMiddleware) open(value string) ([]byte, bool, bool) {
+	body, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, false, false
+	}
+
+	encoding := base64.RawURLEncoding
+	mac, err := encoding.DecodeString(signature)
+	if err != nil {
+		return nil, false, false
+	}
+
+	payload, err := encoding.DecodeString(body)
+	if err != nil {
+		return nil, false, false
+	}
+
+	for i, key := range m.keys {
+		if !hmac.Equal(mac, key.sign(m.opts.Name, body)) {
+			continue
+		}
+
+		if m.opts.Encrypt {
+			size := key.aead.NonceSize()
+			if len(payload) < size {
+				return nil, false, false
+			}
+
+			if payload, err = key.aead.Open(nil, payload[:size], payload[size:], []byte(m.opts.Name)); err != nil {
+				return nil, false, false
+			}
+		}
+
+		return payload, i == 0, true
+	}
+
+	return nil, false, false
+}
+
+// NewSessionMiddleware constructs a new middleware that handles sessions
+// e.g., Router.Use(NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}, Encrypt: true}))
+func NewSessionMiddleware(opts SessionOptions) SessionMiddleware {
+	if opts.Name == "" {
+		opts.Name = "session"
+	}
+
+	if len(opts.Keys) == 0 {
+		trail.Warn("no session keys, sessions will not survive restarts")
+		key := make([]byte, 32)
+		_, _ = rand.Read(key)
+		opts.Keys = [][]byte{key}
+	}
+
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = 24 * time.Hour
+	}
+
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+
+	if opts.SameSite == 0 {
+		opts.SameSite = http.SameSiteLaxMode
+	}
+
+	m := SessionMiddleware{opts: opts}
+	for _, secret := range opts.Keys {
+		m.keys = append(m.keys, newSessionKey(secret))
+	}
+
+	return m
+}
+
+// SessionFromContext gets the session for the request (if any)
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return s
+}
+
+// sessionContextKey is the context key for sessions
+type sessionContextKey struct{}
+
+// Session is the session data for a client
+// changes are saved when the response header is written, so must be made before writing the response
+type Session struct {
+	mutex     sync.Mutex
+	id        string
+	data      sessionData
+	request   *trail.Request
+	modified  bool
+	destroyed bool
+	renewed   string
+}
+
+// Get gets a session value
+func (s *Session) Get(key string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.data.Values[key]
+}
+
+// Set sets a session value
+func (s *Session) Set(key, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.data.Values == nil {
+		s.data.Values = make(map[string]string)
+	}
+
+	s.data.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes a session value
+func (s *Session) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, present := s.data.Values[key]; present {
+		delete(s.data.Values, key)
+		s.modified = true
+	}
+}
+
+// UserId gets the id of the user signed in (if any)
+func (s *Session) UserId() *uuid.UUID {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.data.UserId
+}
+
+// SetUserId signs in the user for the session and request trail
+// the session is renewed to prevent session fixation
+func (s *Session) SetUserId(userId uuid.UUID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.UserId = &userId
+	s.modified = true
+	s.renew()
+	if s.request != nil {
+		s.request.SetUserId(userId)
+	}
+}
+
+// Renew changes the session id while keeping the session data (only applies to server-side sessions)
+func (s *Session) Renew() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.modified = true
+	s.renew()
+}
+
+// Destroy removes the session data and expires the session cookie (e.g., to sign out)
+func (s *Session) Destroy() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data = sessionData{}
+	s.destroyed = true
+}
+
+// renew schedules the removal of the current server-side session id
+func (s *Session) renew() {
+	if s.id != "" && s.renewed == "" {
+		s.renewed = s.id
+	}
+
+	s.id = ""
+}
+
+// sessionData is the session data stored for a client
+type sessionData struct {
+	UserId  *uuid.UUID        `json:"u,omitempty"`
+	Values  map[string]string `json:"v,omitempty"`
+	Expires int64             `json:"e"`
+}
+
+// sessionKey is a key for signing and encrypting sessions
+type sessionKey struct {
+	signing []byte
+	aead    cipher.AEAD
+}
+
+// sign creates the signature of a session cookie
+func (k sessionKey) sign(name, body string) []byte {
+	mac := hmac.New(sha256.New, k.signing)
+	mac.Write([]byte(name + "=" + body))
+	return mac.Sum(nil)
+}
+
+// newSessionKey derives the signing and encryption keys from a secret
+func newSessionKey(secret []byte) sessionKey {
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+
+	block, _ := aes.NewCipher(derive("session encryption"))
+	aead, _ := cipher.NewGCM(block)
+	return sessionKey{signing: derive("session signing"), aead: aead}
+}
+
+// sessionWriter saves the session before the response header is written
+type sessionWriter struct {
+	http.ResponseWriter
+	m     SessionMiddleware
+	s     *Session
+	ctx   context.Context
+	saved bool
+}
+
+func (w *sessionWriter) WriteHeader(statusCode int) {
+	if statusCode >= http.StatusOK {
+		w.save()
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.save()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client
+func (w *sessionWriter) Flush() {
+	w.save()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection (e.g., for WebSockets)
+func (w *sessionWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.save()
+		return hj.Hijack()
+	}
+
+	return nil, nil, http.ErrNotSupported
+}
+
+// Push initiates an HTTP/2 server push
+func (w *sessionWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+// Unwrap gets the underlying response writer (e.g., for http.ResponseController)
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// save sets the session cookie if the session changed
+func (w *sessionWriter) save() {
+	if w.saved {
+		return
+	}
+
+	w.saved = true
+	s, opts := w.s, w.m.opts
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if opts.Store != nil && s.renewed != "" || opts.Store != nil && s.destroyed && s.id != "" {
+		id := s.renewed
+		if id == "" {
+			id = s.id
+		}
+
+		if err := opts.Store.Delete(w.ctx, id); err != nil {
+			trail.Errorf("session %s could not be deleted: %+v", opts.Name, err)
+		}
+	}
+
+	cookie := http.Cookie{
+		Name:     opts.Name,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		Secure:   opts.Secure,
+		HttpOnly: true,
+		SameSite: opts.SameSite,
+	}
+
+	if s.destroyed {
+		cookie.MaxAge = -1
+		http.SetCookie(w.ResponseWriter, &cookie)
+		return
+	}
+
+	if !s.modified {
+		return
+	}
+
+	s.data.Expires = time.Now().Add(opts.MaxAge).Unix()
+	payload, err := json.Marshal(s.data)
+	if err != nil {
+		trail.Errorf("session %s could not be encoded: %+v", opts.Name, err)
+		return
+	}
+
+	if opts.Store != nil {
+		if s.id == "" {
+			id := make([]byte, 32)
+			_, _ = rand.Read(id)
+			s.id = base64.RawURLEncoding.EncodeToString(id)
+		}
+
+		if err := opts.Store.Save(w.ctx, s.id, payload, opts.MaxAge); err != nil {
+			trail.Errorf("session %s could not be saved: %+v", opts.Name, err)
+			return
+		}
+
+		payload = []byte(s.id)
+	}
+
+	value, err := w.m.seal(payload)
+	if err != nil {
+		trail.Errorf("session %s could not be sealed: %+v", opts.Name, err)
+		return
+	}
+
+	cookie.Value = string(value)
+	cookie.MaxAge = int(opts.MaxAge / time.Second)
+	if len(cookie.String()) > 4096 {
+		trail.Errorf("session %s is too large for a cookie, use a session store", opts.Name)
+		return
+	}
+
+	http.SetCookie(w.ResponseWriter, &cookie)
+}
+
+// MemorySessionStore is an in-memory implementation of the session store
+// sessions are lost on restart and not shared between instances
+type MemorySessionStore struct {
+	mutex    sync.Mutex
+	sessions map[string]memorySession
+	swept    time.Time
+}
+
+// memorySession is session data stored in memory
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// Load gets the session data by id
+func (s *MemorySessionStore) Load(_ context.Context, id string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session, present := s.sessions[id]
+	if !present || !time.Now().Before(session.expires) {
+		delete(s.sessions, id)
+		return nil, trail.NewErrorNotFound("session not found")
+	}
+
+	return session.data, nil
+}
+
+// Save stores the session data by id for the ttl
+func (s *MemorySessionStore) Save(_ context.Context, id string, data []byte, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(s.swept) > time.Minute {
+		for id, session := range s.sessions {
+			if !now.Before(session.expires) {
+				delete(s.sessions, id)
+			}
+		}
+
+		s.swept = now
+	}
+
+	s.sessions[id] = memorySession{data: data, expires: now.Add(ttl)}
+	return nil
+}
+
+// Delete removes the session data by id
+func (s *MemorySessionStore) Delete(_ context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// NewMemorySessionStore creates a new in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
@@ -0,0 +1,263 @@
+package tea
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+func TestSessionMiddleware(t *testing.T) {
+	t.Parallel()
+
+	serve := func(m SessionMiddleware, cookie *http.Cookie, handler func(s *Session)) (*httptest.ResponseRecorder, *http.Cookie) {
+		r := httptest.NewRequest("GET", "/tests", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		m.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(SessionFromContext(r.Context()))
+		})).ServeHTTP(w, r)
+
+		cookies := w.Result().Cookies()
+		if len(cookies) == 0 {
+			return w, nil
+		}
+
+		return w, cookies[0]
+	}
+
+	key, rotated := []byte("a secret key"), []byte("a new secret key")
+
+	t.Run("defaults", func(t *testing.T) {
+		m := NewSessionMiddleware(SessionOptions{})
+		assert.Equal(t, "session", m.opts.Name)
+		assert.Len(t, m.keys, 1)
+		assert.Equal(t, 24*time.Hour, m.opts.MaxAge)
+		assert.Equal(t, "/", m.opts.Path)
+		assert.Equal(t, http.SameSiteLaxMode, m.opts.SameSite)
+		assert.Nil(t, SessionFromContext(context.Background()))
+	})
+
+	t.Run("signed cookies", func(t *testing.T) {
+		m := NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}, Secure: true, Domain: "pghq.app"})
+		_, cookie := serve(m, nil, func(s *Session) {
+			assert.Empty(t, s.Get("theme"))
+			s.Set("theme", "dark")
+			s.Set("lang", "en")
+			s.Delete("lang")
+			s.Delete("missing")
+		})
+
+		assert.NotNil(t, cookie)
+		assert.True(t, cookie.HttpOnly)
+		assert.True(t, cookie.Secure)
+		assert.Equal(t, "pghq.app", cookie.Domain)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		assert.Equal(t, 86400, cookie.MaxAge)
+
+		w, unchanged := serve(m, cookie, func(s *Session) {
+			assert.Equal(t, "dark", s.Get("theme"))
+			assert.Empty(t, s.Get("lang"))
+		})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Nil(t, unchanged)
+
+		body, signature, _ := strings.Cut(cookie.Value, ".")
+		for _, value := range []string{"", body, body + ".bad", "bad." + signature, strings.ToUpper(body) + "." + signature} {
+			serve(m, &http.Cookie{Name: "session", Value: value}, func(s *Session) {
+				assert.Empty(t, s.Get("theme"))
+			})
+		}
+
+		serve(NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}, Name: "other"}), &http.Cookie{Name: "other", Value: cookie.Value}, func(s *Session) {
+			assert.Empty(t, s.Get("theme"))
+		})
+	})
+
+	t.Run("encrypted cookies", func(t *testing.T) {
+		m := NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}, Encrypt: true})
+		_, cookie := serve(m, nil, func(s *Session) {
+			s.Set("secret", "a very secret value")
+		})
+
+		assert.NotNil(t, cookie)
+		payload, _, _ := NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}}).open(cookie.Value)
+		assert.NotContains(t, string(payload), "secret")
+
+		serve(m, cookie, func(s *Session) {
+			assert.Equal(t, "a very secret value", s.Get("secret"))
+		})
+
+		serve(NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}, Encrypt: true}), &http.Cookie{Name: "session", Value: "YQ." + strings.SplitN(cookie.Value, ".", 2)[1]}, func(s *Session) {
+			assert.Empty(t, s.Get("secret"))
+		})
+	})
+
+	t.Run("rotates keys", func(t *testing.T) {
+		old := NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}, Encrypt: true})
+		_, cookie := serve(old, nil, func(s *Session) {
+			s.Set("theme", "dark")
+		})
+
+		m := NewSessionMiddleware(SessionOptions{Keys: [][]byte{rotated, key}, Encrypt: true})
+		_, cookie = serve(m, cookie, func(s *Session) {
+			assert.Equal(t, "dark", s.Get("theme"))
+		})
+
+		assert.NotNil(t, cookie)
+		serve(NewSessionMiddleware(SessionOptions{Keys: [][]byte{rotated}, Encrypt: true}), cookie, func(s *Session) {
+			assert.Equal(t, "dark", s.Get("theme"))
+		})
+
+		serve(NewSessionMiddleware(SessionOptions{Keys: [][]byte{[]byte("unknown")}, Encrypt: true}), cookie, func(s *Session) {
+			assert.Empty(t, s.Get("theme"))
+		})
+	})
+
+	t.Run("expires sessions", func(t *testing.T) {
+		m := NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}})
+		payload, _ := json.Marshal(sessionData{Values: map[string]string{"theme": "dark"}, Expires: time.Now().Add(-time.Second).Unix()})
+		value, _ := m.seal(payload)
+		serve(m, &http.Cookie{Name: "session", Value: string(value)}, func(s *Session) {
+			assert.Empty(t, s.Get("theme"))
+		})
+
+		value, _ = m.seal([]byte("not json"))
+		serve(m, &http.Cookie{Name: "session", Value: string(value)}, func(s *Session) {
+			assert.Empty(t, s.Get("theme"))
+		})
+	})
+
+	t.Run("destroys sessions", func(t *testing.T) {
+		m := NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}})
+		_, cookie := serve(m, nil, func(s *Session) {
+			s.Set("theme", "dark")
+		})
+
+		_, cookie = serve(m, cookie, func(s *Session) {
+			s.Destroy()
+			assert.Empty(t, s.Get("theme"))
+		})
+
+		assert.Equal(t, -1, cookie.MaxAge)
+		assert.Empty(t, cookie.Value)
+	})
+
+	t.Run("rejects large sessions", func(t *testing.T) {
+		m := NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}})
+		_, cookie := serve(m, nil, func(s *Session) {
+			s.Set("data", strings.Repeat("a", 4096))
+		})
+
+		assert.Nil(t, cookie)
+	})
+
+	t.Run("stores sessions", func(t *testing.T) {
+		store := NewMemorySessionStore()
+		m := NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}, Store: store, MaxAge: time.Minute})
+		_, cookie := serve(m, nil, func(s *Session) {
+			s.Set("data", strings.Repeat("a", 4096))
+		})
+
+		assert.NotNil(t, cookie)
+		assert.Less(t, len(cookie.Value), 128)
+		assert.Len(t, store.sessions, 1)
+
+		userId := uuid.New()
+		_, renewed := serve(m, cookie, func(s *Session) {
+			assert.Len(t, s.Get("data"), 4096)
+			assert.Nil(t, s.UserId())
+			s.SetUserId(userId)
+		})
+
+		assert.NotEqual(t, cookie.Value, renewed.Value)
+		assert.Len(t, store.sessions, 1)
+		serve(m, cookie, func(s *Session) {
+			assert.Empty(t, s.Get("data"))
+		})
+
+		_, unchanged := serve(m, renewed, func(s *Session) {
+			assert.Equal(t, &userId, s.UserId())
+			s.Renew()
+			s.Destroy()
+		})
+
+		assert.Equal(t, -1, unchanged.MaxAge)
+		assert.Len(t, store.sessions, 0)
+	})
+
+	t.Run("sets the trail user", func(t *testing.T) {
+		userId := uuid.New()
+		r := NewRouter("0")
+		r.Use(NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}}))
+		r.Route("POST", "/sign-in", func(w http.ResponseWriter, r *http.Request) {
+			SessionFromContext(r.Context()).SetUserId(userId)
+			assert.Equal(t, &userId, trail.RequestFromContext(r.Context()).UserId())
+			Send(w, r, "ok")
+		})
+
+		r.Route("GET", "/me", func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, &userId, trail.RequestFromContext(r.Context()).UserId())
+			w.(http.Flusher).Flush()
+		})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/v0/sign-in", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		cookies := w.Result().Cookies()
+		assert.Len(t, cookies, 1)
+
+		req := httptest.NewRequest("GET", "/v0/me", nil)
+		req.AddCookie(cookies[0])
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.True(t, w.Flushed)
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		serve(NewSessionMiddleware(SessionOptions{Keys: [][]byte{key}}), nil, func(s *Session) {})
+		w := sessionWriter{ResponseWriter: httptest.NewRecorder()}
+		_, _, err := w.Hijack()
+		assert.Equal(t, http.ErrNotSupported, err)
+		assert.Equal(t, http.ErrNotSupported, w.Push("/app.js", nil))
+		assert.NotNil(t, w.Unwrap())
+	})
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewMemorySessionStore()
+	_, err := store.Load(ctx, "missing")
+	assert.True(t, trail.IsNotFound(err))
+
+	assert.Nil(t, store.Save(ctx, "expired", []byte("data"), -time.Second))
+	_, err = store.Load(ctx, "expired")
+	assert.True(t, trail.IsNotFound(err))
+
+	assert.Nil(t, store.Save(ctx, "expired", []byte("data"), -time.Second))
+	store.swept = time.Time{}
+	assert.Nil(t, store.Save(ctx, "id", []byte("data"), time.Minute))
+	assert.Len(t, store.sessions, 1)
+
+	data, err := store.Load(ctx, "id")
+	assert.Nil(t, err)
+	assert.Equal(t, "data", string(data))
+
+	assert.Nil(t, store.Delete(ctx, "id"))
+	_, err = store.Load(ctx, "id")
+	assert.True(t, trail.IsNotFound(err))
+}
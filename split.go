@@ -0,0 +1,216 @@
+package tea
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// StableVersion is the version of a director's primary upstream pool
+const StableVersion = "stable"
+
+// split is a share of a director's traffic sent to another version of the upstreams
+type split struct {
+	version string
+	percent int
+	pool    *pool
+}
+
+// MirrorOptions is a policy for shadowing a director's traffic to another upstream
+type MirrorOptions struct {
+	// Host is the upstream receiving copies of requests, its responses are discarded
+	Host string `yaml:"host"`
+
+	// Percent is the share of requests mirrored (default 100)
+	Percent int `yaml:"percent"`
+
+	// Timeout is how long mirrored requests may take before they are cancelled (default 1s)
+	Timeout time.Duration `yaml:"timeout"`
+
+	// MaxBodySize is the max size of request bodies buffered for the mirror (default 1 MB)
+	// requests with larger bodies or bodies of unknown length are not mirrored
+	MaxBodySize int64 `yaml:"maxBodySize"`
+
+	url *url.URL
+}
+
+// version selects the version of the upstreams for the request
+// forced by the override header, otherwise assigned by the sticky key or at random
+func (d *director) version(r *http.Request) (string, *pool) {
+	if d.override != "" {
+		if version := r.Header.Get(d.override); version != "" {
+			for _, s := range d.splits {
+				if s.version == version {
+					return s.version, s.pool
+				}
+			}
+
+			if version == StableVersion {
+				return StableVersion, d.pool
+			}
+		}
+	}
+
+	if len(d.splits) == 0 {
+		return StableVersion, d.pool
+	}
+
+	var bucket int
+	if key := d.stickyKey(r); key != "" {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(d.key()))
+		_, _ = h.Write([]byte(key))
+		bucket = int(h.Sum32() % 100)
+	} else {
+		bucket = rand.Intn(100)
+	}
+
+	for _, s := range d.splits {
+		if bucket < s.percent {
+			return s.version, s.pool
+		}
+
+		bucket -= s.percent
+	}
+
+	return StableVersion, d.pool
+}
+
+// stickyKey gets the key consistently assigning the request to a version (if any)
+func (d *director) stickyKey(r *http.Request) string {
+	if d.sticky == nil {
+		return ""
+	}
+
+	return d.sticky(r)
+}
+
+// upstreams gets the upstreams of every version
+func (d *director) upstreams() []*Upstream {
+	upstreams := d.pool.upstreams
+	for _, s := range d.splits {
+		upstreams = append(upstreams[:len(upstreams):len(upstreams)], s.pool.upstreams...)
+	}
+
+	return upstreams
+}
+
+// mirrored checks whether a copy of the request should be sent to the mirror upstream
+func (d *director) mirrored() bool {
+	return d.shadow != nil && rand.Intn(100) < d.shadow.Percent
+}
+
+// mirror sends a copy of the request to the mirror upstream in the background and discards the response
+// the outcome is recorded in a Proxy.Mirror span added to the request's trail once the mirror responds
+// (so it never delays the response, but mirrors still in flight when the request finishes are not recorded)
+func (d *director) mirror(r *http.Request) {
+	span := trail.StartDetachedSpan(r.Context(), "Proxy.Mirror")
+	span.Tags.Set("Upstream", d.shadow.url.Redacted())
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.shadow.Timeout)
+	m := r.Clone(ctx)
+	m.URL.Scheme = d.shadow.url.Scheme
+	m.URL.Host = d.shadow.url.Host
+	if r.GetBody != nil {
+		m.Body, _ = r.GetBody()
+	}
+
+	go func() {
+		defer cancel()
+		defer span.Finish()
+		resp, err := http.DefaultTransport.RoundTrip(m)
+		if err != nil {
+			span.Tags.Set("Error", err.Error())
+			trail.Warnf("tea.proxy: mirroring %s: %s", m.URL.Redacted(), err)
+			return
+		}
+
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		span.Tags.Set("Status", strconv.Itoa(resp.StatusCode))
+	}()
+}
+
+// WithSplit creates an option sending a percentage of requests to another version of the upstreams
+// (e.g., a canary), the remaining requests are sent to the stable version
+func WithSplit(version string, percent int, hosts ...string) DirectorOption {
+	return func(d *director) error {
+		total := percent
+		for _, s := range d.splits {
+			total += s.percent
+		}
+
+		if percent < 0 || total > 100 {
+			return trail.NewErrorf("split percentages must total at most 100, got %d", total)
+		}
+
+		if version == "" || version == StableVersion || len(hosts) == 0 {
+			return trail.NewErrorf("bad split %s", version)
+		}
+
+		s := split{version: version, percent: percent, pool: newPool()}
+		for _, host := range hosts {
+			upstream, err := newUpstream(host, 1)
+			if err != nil {
+				return err
+			}
+
+			s.pool.upstreams = append(s.pool.upstreams, upstream)
+		}
+
+		d.splits = append(d.splits, &s)
+		return nil
+	}
+}
+
+// WithStickySplit creates an option consistently assigning requests with the same key
+// to the same version (e.g., HashByUserId or HashByCookie), requests without a key are assigned at random
+func WithStickySplit(key HashKey) DirectorOption {
+	return func(d *director) error {
+		d.sticky = key
+		return nil
+	}
+}
+
+// WithSplitOverride creates an option for forcing the version with a request header (e.g., for testers)
+// the header value is the version name or StableVersion
+func WithSplitOverride(header string) DirectorOption {
+	return func(d *director) error {
+		d.override = header
+		return nil
+	}
+}
+
+// WithMirror creates an option for shadowing requests to another upstream
+// mirrored requests are sent in the background and their responses discarded
+func WithMirror(opts MirrorOptions) DirectorOption {
+	return func(d *director) error {
+		mirrorURL, err := url.ParseRequestURI(opts.Host)
+		if err != nil {
+			return err
+		}
+
+		if opts.Percent <= 0 {
+			opts.Percent = 100
+		}
+
+		if opts.Timeout <= 0 {
+			opts.Timeout = time.Second
+		}
+
+		if opts.MaxBodySize <= 0 {
+			opts.MaxBodySize = maxReplaySize
+		}
+
+		opts.url = mirrorURL
+		d.shadow = &opts
+		return nil
+	}
+}
@@ -0,0 +1,332 @@
+package tea
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pghq/go-tea/trail"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirector_Version(t *testing.T) {
+	t.Parallel()
+
+	newDirector := func(t *testing.T, opts ...DirectorOption) *director {
+		d := director{prefix: "users", pool: newPool()}
+		for _, opt := range opts {
+			assert.Nil(t, opt(&d))
+		}
+
+		return &d
+	}
+
+	t.Run("bad split", func(t *testing.T) {
+		d := director{pool: newPool()}
+		assert.NotNil(t, WithSplit("canary", 10)(&d))
+		assert.NotNil(t, WithSplit("", 10, "http://canary.tld")(&d))
+		assert.NotNil(t, WithSplit(StableVersion, 10, "http://canary.tld")(&d))
+		assert.NotNil(t, WithSplit("canary", -1, "http://canary.tld")(&d))
+		assert.NotNil(t, WithSplit("canary", 10, "")(&d))
+		assert.Nil(t, WithSplit("canary", 60, "http://canary.tld")(&d))
+		assert.NotNil(t, WithSplit("beta", 50, "http://beta.tld")(&d))
+	})
+
+	t.Run("no splits", func(t *testing.T) {
+		d := newDirector(t)
+		version, p := d.version(httptest.NewRequest("GET", "/users", nil))
+		assert.Equal(t, StableVersion, version)
+		assert.Equal(t, d.pool, p)
+		assert.Len(t, d.upstreams(), 0)
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		d := newDirector(t, WithSplit("canary", 100, "http://canary.tld"), WithSplit("beta", 0, "http://beta.tld"))
+		assert.Len(t, d.upstreams(), 2)
+		for i := 0; i < 10; i++ {
+			version, p := d.version(httptest.NewRequest("GET", "/users", nil))
+			assert.Equal(t, "canary", version)
+			assert.Equal(t, d.splits[0].pool, p)
+		}
+
+		d = newDirector(t, WithSplit("canary", 50, "http://canary.tld"))
+		versions := make(map[string]int)
+		for i := 0; i < 1000; i++ {
+			version, _ := d.version(httptest.NewRequest("GET", "/users", nil))
+			versions[version]++
+		}
+
+		assert.InDelta(t, 500, versions["canary"], 100)
+		assert.InDelta(t, 500, versions[StableVersion], 100)
+	})
+
+	t.Run("sticky", func(t *testing.T) {
+		d := newDirector(t, WithSplit("canary", 50, "http://canary.tld"), WithStickySplit(HashByCookie("session")))
+		versions := make(map[string]bool)
+		for i := 0; i < 20; i++ {
+			r := httptest.NewRequest("GET", "/users", nil)
+			r.AddCookie(&http.Cookie{Name: "session", Value: strings.Repeat("a", i+1)})
+			version, _ := d.version(r)
+			versions[version] = true
+			for j := 0; j < 5; j++ {
+				again, _ := d.version(r)
+				assert.Equal(t, version, again)
+			}
+		}
+
+		assert.Len(t, versions, 2)
+	})
+
+	t.Run("override", func(t *testing.T) {
+		d := newDirector(t, WithSplit("canary", 0, "http://canary.tld"), WithSplitOverride("X-Version"))
+		r := httptest.NewRequest("GET", "/users", nil)
+		r.Header.Set("X-Version", "canary")
+		version, _ := d.version(r)
+		assert.Equal(t, "canary", version)
+
+		d = newDirector(t, WithSplit("canary", 100, "http://canary.tld"), WithSplitOverride("X-Version"))
+		r.Header.Set("X-Version", StableVersion)
+		version, _ = d.version(r)
+		assert.Equal(t, StableVersion, version)
+
+		r.Header.Set("X-Version", "unknown")
+		version, _ = d.version(r)
+		assert.Equal(t, "canary", version)
+	})
+}
+
+func TestDirector_Mirror(t *testing.T) {
+	t.Parallel()
+
+	t.Run("bad host", func(t *testing.T) {
+		d := director{pool: newPool()}
+		assert.NotNil(t, WithMirror(MirrorOptions{})(&d))
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		d := director{pool: newPool()}
+		assert.Nil(t, WithMirror(MirrorOptions{Host: "http://mirror.tld"})(&d))
+		assert.Equal(t, 100, d.shadow.Percent)
+		assert.Equal(t, time.Second, d.shadow.Timeout)
+		assert.Equal(t, int64(maxReplaySize), d.shadow.MaxBodySize)
+		assert.True(t, d.mirrored())
+
+		assert.Nil(t, WithMirror(MirrorOptions{Host: "http://mirror.tld", Percent: 100, Timeout: time.Minute})(&d))
+		assert.Equal(t, time.Minute, d.shadow.Timeout)
+	})
+
+	t.Run("mirrors in the background", func(t *testing.T) {
+		bodies := make(chan string, 1)
+		release := make(chan struct{})
+		mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			bodies <- r.URL.Path + " " + string(b)
+			select {
+			case <-release:
+				w.WriteHeader(http.StatusTeapot)
+			case <-r.Context().Done():
+			}
+		}))
+		defer mirror.Close()
+
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			_, _ = w.Write(b)
+		}))
+		defer primary.Close()
+
+		upstream, _ := newUpstream(primary.URL, 1)
+		d := director{pool: newPool(upstream)}
+		assert.Nil(t, WithMirror(MirrorOptions{Host: mirror.URL, Timeout: 5 * time.Second})(&d))
+
+		// the mirror only responds once the primary response is received
+		span := trail.StartSpan(context.Background(), "test")
+		r := httptest.NewRequest("POST", "/users", strings.NewReader("body")).WithContext(span.Context())
+		r.RequestURI = ""
+		resp, err := d.RoundTrip(r)
+		assert.Nil(t, err)
+		b, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		assert.Equal(t, "body", string(b))
+
+		select {
+		case body := <-bodies:
+			assert.Equal(t, "/users body", body)
+		case <-time.After(5 * time.Second):
+			t.Fatal("request not mirrored")
+		}
+
+		close(release)
+		var mirrored trail.Span
+		assert.Eventually(t, func() bool {
+			span.Request.Finish()
+			for _, op := range span.Request.Operations() {
+				if op.Operation == "Proxy.Mirror" {
+					mirrored = op
+					return true
+				}
+			}
+
+			return false
+		}, 5*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, span.SpanId, *mirrored.ParentId)
+		assert.Equal(t, "418", mirrored.Tags.Get("Status"))
+		assert.Equal(t, mirror.URL, mirrored.Tags.Get("Upstream"))
+	})
+
+	t.Run("skips large bodies", func(t *testing.T) {
+		paths := make(chan string, 3)
+		mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths <- r.URL.Path
+		}))
+		defer mirror.Close()
+
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			_, _ = w.Write(b)
+		}))
+		defer primary.Close()
+
+		upstream, _ := newUpstream(primary.URL, 1)
+		d := director{pool: newPool(upstream)}
+		assert.Nil(t, WithMirror(MirrorOptions{Host: mirror.URL, MaxBodySize: 16})(&d))
+
+		large := strings.Repeat("a", 1024)
+		for path, body := range map[string]io.Reader{
+			"/large":   strings.NewReader(large),
+			"/unknown": io.MultiReader(strings.NewReader(large)),
+		} {
+			r := httptest.NewRequest("POST", path, body)
+			r.RequestURI = ""
+			resp, err := d.RoundTrip(r)
+			assert.Nil(t, err)
+			b, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.Equal(t, large, string(b))
+		}
+
+		r := httptest.NewRequest("POST", "/small", strings.NewReader("body"))
+		r.RequestURI = ""
+		resp, err := d.RoundTrip(r)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+
+		select {
+		case path := <-paths:
+			assert.Equal(t, "/small", path)
+		case <-time.After(5 * time.Second):
+			t.Fatal("request not mirrored")
+		}
+	})
+
+	t.Run("cancels slow mirrors", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			close(cancelled)
+		}))
+		defer mirror.Close()
+
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer primary.Close()
+
+		upstream, _ := newUpstream(primary.URL, 1)
+		d := director{pool: newPool(upstream)}
+		assert.Nil(t, WithMirror(MirrorOptions{Host: mirror.URL, Timeout: 50 * time.Millisecond})(&d))
+
+		r := httptest.NewRequest("GET", "/users", nil)
+		r.RequestURI = ""
+		resp, err := d.RoundTrip(r)
+		assert.Nil(t, err)
+		_ = resp.Body.Close()
+
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("mirror not cancelled")
+		}
+	})
+
+	t.Run("ignores mirror errors", func(t *testing.T) {
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer primary.Close()
+
+		upstream, _ := newUpstream(primary.URL, 1)
+		d := director{pool: newPool(upstream)}
+		assert.Nil(t, WithMirror(MirrorOptions{Host: "http://localhost:0"})(&d))
+
+		span := trail.StartSpan(context.Background(), "test")
+		r := httptest.NewRequest("GET", "/users", nil).WithContext(span.Context())
+		r.RequestURI = ""
+		resp, err := d.RoundTrip(r)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		_ = resp.Body.Close()
+	})
+}
+
+func TestProxy_Split(t *testing.T) {
+	t.Parallel()
+
+	upstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+
+	t.Run("canary", func(t *testing.T) {
+		stable, canary := upstream("stable"), upstream("canary")
+		defer stable.Close()
+		defer canary.Close()
+
+		p := NewProxy("", WithRoutesEndpoint("/admin/routes"))
+		assert.Nil(t, p.Direct("users", stable.URL, WithSplit("canary", 100, canary.URL), WithSplitOverride("X-Version"), WithCircuitBreaker(CircuitBreakerOptions{})))
+		assert.Contains(t, p.health.Status().Checks, "circuit:"+canary.URL)
+
+		r := httptest.NewRequest("GET", "/users/1", nil)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, "canary", w.Body.String())
+
+		r.Header.Set("X-Version", StableVersion)
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		assert.Equal(t, "stable", w.Body.String())
+
+		routes := p.Routes()
+		assert.Equal(t, map[string]int{StableVersion: 0, "canary": 100}, routes[0].Splits)
+		assert.Equal(t, StableVersion, routes[0].Upstreams[0].Version)
+		assert.Equal(t, "canary", routes[0].Upstreams[1].Version)
+	})
+
+	t.Run("routes file", func(t *testing.T) {
+		for _, sticky := range []string{"userId", "cookie:session", "header:X-User"} {
+			r := ProxyRoute{
+				Prefix:    "users",
+				Upstreams: []ProxyUpstream{{URL: "http://stable.tld"}},
+				Splits:    []ProxySplit{{Version: "canary", Percent: 5, Upstreams: []string{"http://canary.tld"}}},
+				Sticky:    sticky,
+				Override:  "X-Version",
+				Mirror:    &MirrorOptions{Host: "http://mirror.tld", Percent: 10},
+			}
+
+			opts, err := r.options()
+			assert.Nil(t, err)
+			d, err := NewProxy("").director(r.Prefix, opts...)
+			assert.Nil(t, err)
+			assert.Len(t, d.splits, 1)
+			assert.NotNil(t, d.sticky)
+			assert.Equal(t, "X-Version", d.override)
+			assert.Equal(t, 10, d.shadow.Percent)
+		}
+
+		_, err := ProxyRoute{Prefix: "users", Sticky: "random"}.options()
+		assert.NotNil(t, err)
+	})
+}
@@ -139,6 +139,21 @@ func IsTooManyRequests(err error) bool {
 	return err != nil && StatusCode(err) == http.StatusTooManyRequests
 }
 
+// ErrorPayloadTooLarge creates a payload too large error
+func ErrorPayloadTooLarge(err error) error {
+	return errorTransfer(http.StatusRequestEntityTooLarge, err)
+}
+
+// NewErrorPayloadTooLarge creates a payload too large error from a msg
+func NewErrorPayloadTooLarge(msg string) error {
+	return NewErrorWithCode(msg, http.StatusRequestEntityTooLarge)
+}
+
+// IsPayloadTooLarge checks if an error is a payload too large application error
+func IsPayloadTooLarge(err error) bool {
+	return err != nil && StatusCode(err) == http.StatusRequestEntityTooLarge
+}
+
 // ErrorNotAuthorized creates an unauthorized error
 func ErrorNotAuthorized(err error) error {
 	return errorTransfer(http.StatusUnauthorized, err)
@@ -154,6 +169,51 @@ func IsNotAuthorized(err error) bool {
 	return IsError(err, context.Canceled) || err != nil && StatusCode(err) == http.StatusUnauthorized
 }
 
+// ErrorForbidden creates a forbidden error
+func ErrorForbidden(err error) error {
+	return errorTransfer(http.StatusForbidden, err)
+}
+
+// NewErrorForbidden creates a forbidden error from a msg
+func NewErrorForbidden(msg string) error {
+	return NewErrorWithCode(msg, http.StatusForbidden)
+}
+
+// IsForbidden checks if an error is a forbidden application error
+func IsForbidden(err error) bool {
+	return err != nil && StatusCode(err) == http.StatusForbidden
+}
+
+// ErrorBadGateway creates a bad gateway error
+func ErrorBadGateway(err error) error {
+	return errorTransfer(http.StatusBadGateway, err)
+}
+
+// NewErrorBadGateway creates a bad gateway error from a msg
+func NewErrorBadGateway(msg string) error {
+	return NewErrorWithCode(msg, http.StatusBadGateway)
+}
+
+// IsBadGateway checks if an error is a bad gateway application error
+func IsBadGateway(err error) bool {
+	return err != nil && StatusCode(err) == http.StatusBadGateway
+}
+
+// ErrorServiceUnavailable creates a service unavailable error
+func ErrorServiceUnavailable(err error) error {
+	return errorTransfer(http.StatusServiceUnavailable, err)
+}
+
+// NewErrorServiceUnavailable creates a service unavailable error from a msg
+func NewErrorServiceUnavailable(msg string) error {
+	return NewErrorWithCode(msg, http.StatusServiceUnavailable)
+}
+
+// IsServiceUnavailable checks if an error is a service unavailable application error
+func IsServiceUnavailable(err error) bool {
+	return err != nil && StatusCode(err) == http.StatusServiceUnavailable
+}
+
 // AsError finds first error in chain matching target.
 func AsError(err error, target interface{}) bool {
 	// why would we ever want to panic on this call???
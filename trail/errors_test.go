@@ -80,6 +80,14 @@ func TestErrorConflict(t *testing.T) {
 	})
 }
 
+func TestErrorPayloadTooLarge(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok", func(t *testing.T) {
+		assert.True(t, IsPayloadTooLarge(ErrorPayloadTooLarge(NewErrorPayloadTooLarge("a message"))))
+	})
+}
+
 func TestErrorTooManyRequests(t *testing.T) {
 	t.Parallel()
 
@@ -96,6 +104,31 @@ func TestErrorNotAuthorized(t *testing.T) {
 	})
 }
 
+func TestErrorForbidden(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok", func(t *testing.T) {
+		assert.True(t, IsForbidden(ErrorForbidden(NewErrorForbidden("a message"))))
+		assert.False(t, IsForbidden(NewErrorNotAuthorized("a message")))
+	})
+}
+
+func TestErrorBadGateway(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok", func(t *testing.T) {
+		assert.True(t, IsBadGateway(ErrorBadGateway(NewErrorBadGateway("a message"))))
+	})
+}
+
+func TestErrorServiceUnavailable(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ok", func(t *testing.T) {
+		assert.True(t, IsServiceUnavailable(ErrorServiceUnavailable(NewErrorServiceUnavailable("a message"))))
+	})
+}
+
 func TestAsError(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,198 @@
+package trail
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	// globalIPResolver is the global client ip resolver
+	globalIPResolver = NewIPResolver()
+)
+
+// SetIPResolver sets the global client ip resolver used by requests
+func SetIPResolver(resolver *IPResolver) {
+	globalIPResolver = resolver
+}
+
+// ClientIP gets the ip of the client making the request
+// using the resolver of the request context (if any) or the global resolver
+func ClientIP(r *http.Request) net.IP {
+	if resolver, ok := r.Context().Value(ipResolverContextKey{}).(*IPResolver); ok {
+		return resolver.ClientIP(r)
+	}
+
+	return globalIPResolver.ClientIP(r)
+}
+
+// WithIPResolver creates a context resolving client ips of requests with the resolver
+// (e.g., for a proxy trusting different proxies than the rest of the application)
+func WithIPResolver(ctx context.Context, resolver *IPResolver) context.Context {
+	return context.WithValue(ctx, ipResolverContextKey{}, resolver)
+}
+
+// ipResolverContextKey is the context key for client ip resolvers
+type ipResolverContextKey struct{}
+
+// IPResolver resolves the ip of the client making a request
+// forwarding headers are only consulted for requests from trusted proxies
+type IPResolver struct {
+	trusted []*net.IPNet
+	headers []string
+}
+
+// ClientIP gets the ip of the client making the request
+// multi-hop headers (Forwarded, X-Forwarded-For) resolve to the rightmost untrusted address
+func (r *IPResolver) ClientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if !r.Trusted(ip) {
+		return ip
+	}
+
+	for _, header := range r.headers {
+		var hops []net.IP
+		switch http.CanonicalHeaderKey(header) {
+		case "Forwarded":
+			hops = forwardedFor(req.Header.Values(header))
+		case "X-Forwarded-For":
+			hops = forwardedIPs(req.Header.Values(header))
+		default:
+			if hop := net.ParseIP(strings.TrimSpace(req.Header.Get(header))); hop != nil {
+				return hop
+			}
+		}
+
+		for i := len(hops) - 1; i >= 0 && hops[i] != nil; i-- {
+			if !r.Trusted(hops[i]) || i == 0 {
+				return hops[i]
+			}
+		}
+	}
+
+	return ip
+}
+
+// Trusted checks whether the ip belongs to a trusted proxy
+func (r *IPResolver) Trusted(ip net.IP) bool {
+	if r == nil || ip == nil {
+		return false
+	}
+
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IPResolverOption is a handler for configuring the client ip resolver
+type IPResolverOption func(r *IPResolver)
+
+// WithTrustedProxies creates an option for trusting forwarding headers from proxies within the networks (CIDRs or ips)
+func WithTrustedProxies(networks ...string) IPResolverOption {
+	return func(r *IPResolver) {
+		for _, network := range networks {
+			ipNet, err := ParseNetwork(network)
+			if err != nil {
+				Warnf("tea.trail: ignoring trusted proxy %s: %s", network, err)
+				continue
+			}
+
+			r.trusted = append(r.trusted, ipNet)
+		}
+	}
+}
+
+// WithIPHeaders creates an option for the headers consulted (in order) for the client ip
+// (defaults to Forwarded and X-Forwarded-For, single value headers such as X-Real-IP
+// or CF-Connecting-IP should only be used if the trusted proxies always set them)
+func WithIPHeaders(headers ...string) IPResolverOption {
+	return func(r *IPResolver) {
+		r.headers = headers
+	}
+}
+
+// NewIPResolver creates a new client ip resolver
+func NewIPResolver(opts ...IPResolverOption) *IPResolver {
+	r := IPResolver{
+		headers: []string{"Forwarded", "X-Forwarded-For"},
+	}
+
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	return &r
+}
+
+// ParseNetwork parses a CIDR or a single ip as a network
+func ParseNetwork(network string) (*net.IPNet, error) {
+	if strings.Contains(network, "/") {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, Stacktrace(err)
+		}
+
+		return ipNet, nil
+	}
+
+	ip := net.ParseIP(network)
+	if ip == nil {
+		return nil, NewErrorf("invalid ip %s", network)
+	}
+
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// forwardedIPs parses the addresses in X-Forwarded-For headers (in order)
+func forwardedIPs(values []string) []net.IP {
+	var ips []net.IP
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			ips = append(ips, parseNode(hop))
+		}
+	}
+
+	return ips
+}
+
+// forwardedFor parses the for parameters of Forwarded headers (in order, RFC 7239)
+func forwardedFor(values []string) []net.IP {
+	var ips []net.IP
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, node, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					ips = append(ips, parseNode(node))
+				}
+			}
+		}
+	}
+
+	return ips
+}
+
+// parseNode parses an ip from a forwarding header node (e.g., "[2001:db8::1]:4711", 192.0.2.1:80)
+func parseNode(node string) net.IP {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+
+	return net.ParseIP(strings.Trim(node, "[]"))
+}
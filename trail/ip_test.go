@@ -0,0 +1,118 @@
+package trail
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPResolver_ClientIP(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ignores headers from untrusted clients", func(t *testing.T) {
+		resolver := NewIPResolver(WithTrustedProxies("10.0.0.0/8"))
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+		r.Header.Set("Forwarded", "for=1.2.3.4")
+		assert.Equal(t, "192.0.2.1", resolver.ClientIP(r).String())
+		assert.Equal(t, "192.0.2.1", NewIPResolver().ClientIP(r).String())
+	})
+
+	t.Run("rightmost untrusted X-Forwarded-For", func(t *testing.T) {
+		resolver := NewIPResolver(WithTrustedProxies("10.0.0.0/8"), WithIPHeaders("X-Forwarded-For"))
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Add("X-Forwarded-For", "6.6.6.6, 1.2.3.4")
+		r.Header.Add("X-Forwarded-For", "10.0.0.2")
+		assert.Equal(t, "1.2.3.4", resolver.ClientIP(r).String())
+	})
+
+	t.Run("leftmost when all trusted", func(t *testing.T) {
+		resolver := NewIPResolver(WithTrustedProxies("10.0.0.0/8"))
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", "10.0.0.3, 10.0.0.2")
+		assert.Equal(t, "10.0.0.3", resolver.ClientIP(r).String())
+	})
+
+	t.Run("forwarded", func(t *testing.T) {
+		resolver := NewIPResolver(WithTrustedProxies("10.0.0.0/8", "2001:db8::1"))
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.RemoteAddr = "[2001:db8::1]:1234"
+		r.Header.Set("Forwarded", `for="[2001:db8::cafe]:4711";proto=https, For=10.0.0.2`)
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+		assert.Equal(t, "2001:db8::cafe", resolver.ClientIP(r).String())
+	})
+
+	t.Run("single value headers", func(t *testing.T) {
+		resolver := NewIPResolver(WithTrustedProxies("10.0.0.1"), WithIPHeaders("CF-Connecting-IP", "X-Real-IP"))
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Real-IP", "1.2.3.4")
+		assert.Equal(t, "1.2.3.4", resolver.ClientIP(r).String())
+
+		r.Header.Set("CF-Connecting-IP", "5.6.7.8")
+		assert.Equal(t, "5.6.7.8", resolver.ClientIP(r).String())
+	})
+
+	t.Run("falls back to remote address", func(t *testing.T) {
+		resolver := NewIPResolver(WithTrustedProxies("10.0.0.0/8", "bad"))
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", "unknown")
+		assert.Equal(t, "10.0.0.1", resolver.ClientIP(r).String())
+
+		r.RemoteAddr = "10.0.0.1"
+		assert.Equal(t, "10.0.0.1", resolver.ClientIP(r).String())
+		assert.False(t, resolver.Trusted(nil))
+	})
+
+	t.Run("global resolver", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/test", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+		assert.Equal(t, "127.0.0.1", ClientIP(r).String())
+
+		req, err := NewRequest(httptest.NewRecorder(), r, "1.0.0")
+		assert.Nil(t, err)
+		assert.Equal(t, "127.0.0.1", req.IP().String())
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest("GET", "/test", nil)
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	assert.Equal(t, "192.0.2.1", ClientIP(r).String())
+
+	r = r.WithContext(WithIPResolver(r.Context(), NewIPResolver(WithTrustedProxies("192.0.2.1"))))
+	assert.Equal(t, "1.2.3.4", ClientIP(r).String())
+}
+
+func TestParseNetwork(t *testing.T) {
+	t.Parallel()
+
+	t.Run("bad cidr", func(t *testing.T) {
+		_, err := ParseNetwork("10.0.0.0/99")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("bad ip", func(t *testing.T) {
+		_, err := ParseNetwork("bad")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("single ip", func(t *testing.T) {
+		ipNet, err := ParseNetwork("192.0.2.1")
+		assert.Nil(t, err)
+		assert.True(t, ipNet.Contains(net.ParseIP("192.0.2.1")))
+		assert.False(t, ipNet.Contains(net.ParseIP("192.0.2.2")))
+
+		ipNet, err = ParseNetwork("2001:db8::1")
+		assert.Nil(t, err)
+		assert.True(t, ipNet.Contains(net.ParseIP("2001:db8::1")))
+	})
+}
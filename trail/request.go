@@ -292,6 +292,15 @@ func (r *Request) Trail() string {
 	return trail
 }
 
+// RequestFromContext gets the trail request for a context (if any)
+func RequestFromContext(ctx context.Context) *Request {
+	if span, ok := ctx.Value(spanContextKey{}).(*Span); ok {
+		return span.Request
+	}
+
+	return nil
+}
+
 // NewRequest creates a new trail request instance (or continues from a prev one)
 func NewRequest(w http.ResponseWriter, r *http.Request, version string) (*Request, error) {
 	ctx := r.Context()
@@ -327,7 +336,7 @@ func NewRequest(w http.ResponseWriter, r *http.Request, version string) (*Reques
 			userAgent: r.UserAgent(),
 			url:       r.URL,
 			method:    r.Method,
-			ip:        net.ParseIP(r.Header.Get("X-Forwarded-For")),
+			ip:        ClientIP(r),
 			version:   version,
 			referrer:  r.Header.Get("Referrer"),
 		}
@@ -1,6 +1,7 @@
 package trail
 
 import (
+	"context"
 	"fmt"
 	"net/http/httptest"
 	"testing"
@@ -121,3 +122,18 @@ func TestNewRequest(t *testing.T) {
 	})
 
 }
+
+func TestRequestFromContext(t *testing.T) {
+	t.Run("without span", func(t *testing.T) {
+		assert.Nil(t, RequestFromContext(context.Background()))
+	})
+
+	t.Run("with span", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/test", nil)
+		req, err := NewRequest(httptest.NewRecorder(), r, "1.0.0")
+		assert.Nil(t, err)
+
+		span := StartSpan(req.Origin().Context(), "child")
+		assert.Equal(t, req, RequestFromContext(span.Context()))
+	})
+}
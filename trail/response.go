@@ -1,16 +1,23 @@
 package trail
 
 import (
+	"bufio"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
 type httpSpanWriter struct {
 	r               *Request
 	w               http.ResponseWriter
 	withTrailHeader bool
+	wroteHeader     bool
 }
 
 func (w *httpSpanWriter) WriteHeader(statusCode int) {
+	w.wroteHeader = true
 	w.r.AddResponseHeaders(w.Header())
 	w.r.SetStatus(statusCode)
 	w.r.Finish()
@@ -29,5 +36,86 @@ func (w *httpSpanWriter) Header() http.Header {
 }
 
 func (w *httpSpanWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
 	return w.w.Write(b)
 }
+
+// Flush sends any buffered data to the client (e.g., for server-sent events)
+func (w *httpSpanWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection (e.g., for WebSockets)
+// the connection is traced as a long-lived HTTP.Hijack span and the request finishes once it is closed
+func (w *httpSpanWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil || w.wroteHeader {
+		return conn, rw, err
+	}
+
+	w.wroteHeader = true
+	return &spanConn{Conn: conn, span: StartSpan(w.r.Context(), "HTTP.Hijack"), r: w.r}, rw, nil
+}
+
+// Push initiates an HTTP/2 server push
+func (w *httpSpanWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.w.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+// Unwrap gets the underlying response writer (e.g., for http.ResponseController)
+func (w *httpSpanWriter) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
+// spanConn is a hijacked connection traced until it is closed
+type spanConn struct {
+	net.Conn
+	span    *Span
+	r       *Request
+	read    int64
+	written int64
+	once    sync.Once
+}
+
+func (c *spanConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func (c *spanConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+func (c *spanConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.span.Tags.Set("BytesRead", strconv.FormatInt(atomic.LoadInt64(&c.read), 10))
+		c.span.Tags.Set("BytesWritten", strconv.FormatInt(atomic.LoadInt64(&c.written), 10))
+		c.span.Finish()
+		c.r.SetStatus(http.StatusSwitchingProtocols)
+		c.r.Finish()
+	})
+
+	return err
+}
@@ -0,0 +1,93 @@
+package trail
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpSpanWriter(t *testing.T) {
+	t.Parallel()
+
+	serve := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		NewTraceMiddleware("1.0.0", false)(handler).ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+		return w
+	}
+
+	t.Run("implicit header", func(t *testing.T) {
+		w := serve(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hello"))
+		})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Request-Id"))
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		w := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.(http.Flusher).Flush()
+		})
+
+		assert.True(t, w.Flushed)
+		assert.NotEmpty(t, w.Header().Get("Request-Id"))
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		serve(func(w http.ResponseWriter, r *http.Request) {
+			_, _, err := w.(http.Hijacker).Hijack()
+			assert.Equal(t, http.ErrNotSupported, err)
+			assert.Equal(t, http.ErrNotSupported, w.(http.Pusher).Push("/app.js", nil))
+		})
+	})
+
+	t.Run("hijack", func(t *testing.T) {
+		requests := make(chan *Request, 1)
+		s := httptest.NewServer(NewTraceMiddleware("1.0.0", false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			assert.Nil(t, err)
+			_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+			_ = rw.Flush()
+
+			b := make([]byte, 4)
+			_, _ = io.ReadFull(rw, b)
+			_, _ = conn.Write(b)
+			_ = conn.Close()
+			requests <- RequestFromContext(r.Context())
+		})))
+		defer s.Close()
+
+		conn, err := net.Dial("tcp", s.Listener.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+
+		_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n"))
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+		_, _ = conn.Write([]byte("ping"))
+		b := make([]byte, 4)
+		_, _ = io.ReadFull(br, b)
+		assert.Equal(t, "ping", string(b))
+
+		req := <-requests
+		assert.Equal(t, http.StatusSwitchingProtocols, req.Status())
+		var hijacked *Span
+		for _, op := range req.Operations() {
+			if op.Operation == "HTTP.Hijack" {
+				op := op
+				hijacked = &op
+			}
+		}
+
+		assert.NotNil(t, hijacked)
+		assert.Equal(t, "4", hijacked.Tags.Get("BytesWritten"))
+	})
+}
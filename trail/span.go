@@ -28,6 +28,7 @@ type Span struct {
 	ctx      context.Context
 	bundle   *bundle
 	sentry   *sentry.Span
+	detached bool
 	*Request `json:"-"`
 }
 
@@ -58,6 +59,9 @@ func (s *Span) Recover(err interface{}) {
 func (s *Span) Finish() {
 	if s.EndTime.IsZero() {
 		s.EndTime = time.Now()
+		if s.detached {
+			s.bundle.add(s)
+		}
 	}
 }
 
@@ -76,6 +80,21 @@ func (s *Span) sentryHub() *sentry.Hub {
 
 // StartSpan starts a new span instance (or continues from a parent)
 func StartSpan(ctx context.Context, operation string) *Span {
+	node := newSpan(ctx, operation)
+	node.bundle.add(node)
+	return node
+}
+
+// StartDetachedSpan starts a span for work outliving the parent (e.g., in the background)
+// it is only added to the trail once finished, so it is missing from requests finished first
+func StartDetachedSpan(ctx context.Context, operation string) *Span {
+	node := newSpan(ctx, operation)
+	node.detached = true
+	return node
+}
+
+// newSpan creates a span continuing from the parent in the context (if any)
+func newSpan(ctx context.Context, operation string) *Span {
 	parent, hasParent := ctx.Value(spanContextKey{}).(*Span)
 	var node Span
 	node = Span{
@@ -100,8 +119,6 @@ func StartSpan(ctx context.Context, operation string) *Span {
 	}
 
 	node.sentry = sentry.StartSpan(ctx, operation)
-	node.bundle.add(&node)
-
 	return &node
 }
 
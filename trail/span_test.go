@@ -24,6 +24,37 @@ func TestStartSpan(t *testing.T) {
 	})
 }
 
+func TestStartDetachedSpan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("adds the span once finished", func(t *testing.T) {
+		parent := StartSpan(context.TODO(), "parent")
+		detached := StartDetachedSpan(parent.Context(), "detached")
+		assert.Equal(t, parent.SpanId, *detached.ParentId)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			detached.Tags.Set("key", "value")
+			detached.Finish()
+		}()
+
+		parent.Request.Finish()
+		<-done
+		parent.Request.Finish()
+
+		var operations []string
+		for _, op := range parent.Request.Operations() {
+			operations = append(operations, op.Operation)
+			if op.Operation == "detached" {
+				assert.Equal(t, "value", op.Tags.Get("key"))
+			}
+		}
+
+		assert.Contains(t, operations, "detached")
+	})
+}
+
 func TestSpan_Capture(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,283 @@
+package tea
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pghq/go-tea/trail"
+)
+
+// healthCheckTimeout is the max duration of upstream health checks
+const healthCheckTimeout = 5 * time.Second
+
+// Upstream is a member of a director's pool of upstream hosts
+type Upstream struct {
+	URL    *url.URL
+	Weight int
+
+	breaker   *circuitBreaker
+	active    int64
+	unhealthy int32
+	checkedAt int64
+}
+
+// Connections gets the number of in-flight requests to the upstream
+func (u *Upstream) Connections() int64 {
+	return atomic.LoadInt64(&u.active)
+}
+
+// Healthy checks whether the upstream passed its last health check
+func (u *Upstream) Healthy() bool {
+	return atomic.LoadInt32(&u.unhealthy) == 0
+}
+
+// acquire marks the start of a request to the upstream
+func (u *Upstream) acquire() {
+	atomic.AddInt64(&u.active, 1)
+}
+
+// release marks the end of a request to the upstream
+func (u *Upstream) release() {
+	atomic.AddInt64(&u.active, -1)
+}
+
+// check refreshes the health of the upstream using its health status endpoint
+// upstreams are unhealthy unless the endpoint responds with a 2xx JSON status before the timeout
+func (u *Upstream) check(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var unhealthy int32
+	if !u.probe(ctx) {
+		unhealthy = 1
+	}
+
+	atomic.StoreInt32(&u.unhealthy, unhealthy)
+}
+
+// probe requests the health status endpoint of the upstream
+func (u *Upstream) probe(ctx context.Context) bool {
+	healthURL := *u.URL
+	healthURL.Path = path.Join(healthURL.Path, "/health/status")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL.String(), nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+
+	defer resp.Body.Close()
+	body := io.LimitReader(resp.Body, 1<<20)
+	defer func() { _, _ = io.Copy(io.Discard, body) }()
+
+	var status map[string]interface{}
+	return resp.StatusCode/100 == 2 && json.NewDecoder(body).Decode(&status) == nil
+}
+
+// newUpstream creates a new upstream from a host url
+func newUpstream(host string, weight int) (*Upstream, error) {
+	hostURL, err := url.ParseRequestURI(host)
+	if err != nil {
+		return nil, trail.Stacktrace(err)
+	}
+
+	if weight < 1 {
+		weight = 1
+	}
+
+	return &Upstream{URL: hostURL, Weight: weight}, nil
+}
+
+// pool is a load-balanced set of upstreams
+type pool struct {
+	upstreams   []*Upstream
+	balancer    Balancer
+	healthCheck time.Duration
+	now         func() time.Time
+}
+
+// next selects an upstream for the request from the healthy members of the pool
+func (p *pool) next(r *http.Request) *Upstream {
+	available := make([]*Upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if p.healthCheck > 0 {
+			// the check is claimed before it starts so each upstream is checked at most once per interval
+			now := p.now().UnixNano()
+			checkedAt := atomic.LoadInt64(&u.checkedAt)
+			if time.Duration(now-checkedAt) > p.healthCheck && atomic.CompareAndSwapInt64(&u.checkedAt, checkedAt, now) {
+				go u.check(p.checkTimeout())
+			}
+		}
+
+		if u.Healthy() && (u.breaker == nil || u.breaker.ready()) {
+			available = append(available, u)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil
+	}
+
+	return p.balancer.Next(r, available)
+}
+
+// checkTimeout gets the max duration of health checks (at most the check interval)
+func (p *pool) checkTimeout() time.Duration {
+	if p.healthCheck < healthCheckTimeout {
+		return p.healthCheck
+	}
+
+	return healthCheckTimeout
+}
+
+// newPool creates a new pool of upstreams using round-robin balancing
+func newPool(upstreams ...*Upstream) *pool {
+	return &pool{
+		upstreams: upstreams,
+		balancer:  RoundRobin(),
+		now:       time.Now,
+	}
+}
+
+// Balancer selects an upstream for a request from the available members of a pool
+type Balancer interface {
+	Next(r *http.Request, upstreams []*Upstream) *Upstream
+}
+
+// RoundRobin creates a balancer cycling through upstreams in order
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	n uint64
+}
+
+func (b *roundRobin) Next(_ *http.Request, upstreams []*Upstream) *Upstream {
+	n := atomic.AddUint64(&b.n, 1) - 1
+	return upstreams[n%uint64(len(upstreams))]
+}
+
+// LeastConnections creates a balancer choosing the upstream with the fewest in-flight requests
+func LeastConnections() Balancer {
+	return leastConnections{}
+}
+
+type leastConnections struct{}
+
+func (b leastConnections) Next(_ *http.Request, upstreams []*Upstream) *Upstream {
+	var selected *Upstream
+	for _, u := range upstreams {
+		if selected == nil || u.Connections()*int64(selected.Weight) < selected.Connections()*int64(u.Weight) {
+			selected = u
+		}
+	}
+
+	return selected
+}
+
+// Weighted creates a balancer distributing requests in proportion to upstream weights
+// using smooth weighted round-robin
+func Weighted() Balancer {
+	return &weighted{current: make(map[*Upstream]int)}
+}
+
+type weighted struct {
+	mutex   sync.Mutex
+	current map[*Upstream]int
+}
+
+func (b *weighted) Next(_ *http.Request, upstreams []*Upstream) *Upstream {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var selected *Upstream
+	var total int
+	for _, u := range upstreams {
+		b.current[u] += u.Weight
+		total += u.Weight
+		if selected == nil || b.current[u] > b.current[selected] {
+			selected = u
+		}
+	}
+
+	b.current[selected] -= total
+	return selected
+}
+
+// HashKey gets the key used to consistently assign a request to an upstream
+type HashKey func(r *http.Request) string
+
+// HashByHeader creates a hash key from a request header
+func HashByHeader(name string) HashKey {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// HashByCookie creates a hash key from a request cookie
+func HashByCookie(name string) HashKey {
+	return func(r *http.Request) string {
+		if cookie, err := r.Cookie(name); err == nil {
+			return cookie.Value
+		}
+
+		return ""
+	}
+}
+
+// HashByUserId creates a hash key from the user id of the trail request
+func HashByUserId() HashKey {
+	return func(r *http.Request) string {
+		if req := trail.RequestFromContext(r.Context()); req != nil && req.UserId() != nil {
+			return req.UserId().String()
+		}
+
+		return ""
+	}
+}
+
+// ConsistentHash creates a balancer assigning requests with the same key to the same upstream
+// using rendezvous hashing, so only requests for an ejected upstream are reassigned.
+// Requests without a key are balanced round-robin.
+func ConsistentHash(key HashKey) Balancer {
+	return &consistentHash{key: key, fallback: RoundRobin()}
+}
+
+type consistentHash struct {
+	key      HashKey
+	fallback Balancer
+}
+
+func (b *consistentHash) Next(r *http.Request, upstreams []*Upstream) *Upstream {
+	key := b.key(r)
+	if key == "" {
+		return b.fallback.Next(r, upstreams)
+	}
+
+	var selected *Upstream
+	var best float64
+	for _, u := range upstreams {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte(u.URL.String()))
+		score := -float64(u.Weight) / math.Log((float64(h.Sum64()>>11)+0.5)/float64(1<<53))
+		if selected == nil || score > best {
+			selected, best = u, score
+		}
+	}
+
+	return selected
+}
@@ -0,0 +1,182 @@
+package tea
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pghq/go-tea/trail"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalancer(t *testing.T) {
+	t.Parallel()
+
+	upstreams := func(t *testing.T, weights ...int) []*Upstream {
+		var upstreams []*Upstream
+		for i, weight := range weights {
+			u, err := newUpstream("http://"+string(rune('a'+i))+".tld", weight)
+			assert.Nil(t, err)
+			upstreams = append(upstreams, u)
+		}
+
+		return upstreams
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		b := RoundRobin()
+		us := upstreams(t, 1, 1, 1)
+		r := httptest.NewRequest("GET", "/", nil)
+		assert.Equal(t, us[0], b.Next(r, us))
+		assert.Equal(t, us[1], b.Next(r, us))
+		assert.Equal(t, us[2], b.Next(r, us))
+		assert.Equal(t, us[0], b.Next(r, us))
+	})
+
+	t.Run("least connections", func(t *testing.T) {
+		b := LeastConnections()
+		us := upstreams(t, 1, 1, 2)
+		r := httptest.NewRequest("GET", "/", nil)
+		us[0].acquire()
+		us[2].acquire()
+		assert.Equal(t, us[1], b.Next(r, us))
+		us[1].acquire()
+		assert.Equal(t, us[2], b.Next(r, us))
+		us[0].release()
+		assert.Equal(t, us[0], b.Next(r, us))
+		assert.Equal(t, int64(0), us[0].Connections())
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		b := Weighted()
+		us := upstreams(t, 5, 1, 1)
+		r := httptest.NewRequest("GET", "/", nil)
+		counts := make(map[*Upstream]int)
+		var sequence []*Upstream
+		for i := 0; i < 7; i++ {
+			u := b.Next(r, us)
+			counts[u]++
+			sequence = append(sequence, u)
+		}
+
+		assert.Equal(t, 5, counts[us[0]])
+		assert.Equal(t, 1, counts[us[1]])
+		assert.Equal(t, 1, counts[us[2]])
+		assert.Equal(t, []*Upstream{us[0], us[0], us[1], us[0], us[2], us[0], us[0]}, sequence)
+	})
+
+	t.Run("consistent hash", func(t *testing.T) {
+		b := ConsistentHash(HashByHeader("X-Tenant"))
+		us := upstreams(t, 1, 1, 1, 1)
+		r := httptest.NewRequest("GET", "/", nil)
+
+		assert.NotEqual(t, b.Next(r, us), b.Next(r, us))
+
+		assignments := make(map[string]*Upstream)
+		for _, tenant := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+			r.Header.Set("X-Tenant", tenant)
+			assignments[tenant] = b.Next(r, us)
+			assert.Equal(t, assignments[tenant], b.Next(r, us))
+		}
+
+		for tenant, u := range assignments {
+			if u == us[0] {
+				continue
+			}
+
+			r.Header.Set("X-Tenant", tenant)
+			assert.Equal(t, u, b.Next(r, us[1:]))
+		}
+	})
+
+	t.Run("hash keys", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		assert.Empty(t, HashByCookie("session")(r))
+		assert.Empty(t, HashByUserId()(r))
+
+		r.AddCookie(&http.Cookie{Name: "session", Value: "foo"})
+		assert.Equal(t, "foo", HashByCookie("session")(r))
+
+		userId := uuid.New()
+		span := trail.StartSpan(context.Background(), "test")
+		span.SetUserId(userId)
+		r = r.WithContext(span.Context())
+		assert.Equal(t, userId.String(), HashByUserId()(r))
+	})
+}
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ejects unhealthy upstreams", func(t *testing.T) {
+		healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"status": "healthy"}`))
+		}))
+		defer healthy.Close()
+
+		unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer unhealthy.Close()
+
+		a, _ := newUpstream(healthy.URL, 1)
+		b, _ := newUpstream(unhealthy.URL, 1)
+		p := newPool(a, b)
+		p.healthCheck = time.Minute
+
+		r := httptest.NewRequest("GET", "/", nil)
+		assert.NotNil(t, p.next(r))
+		assert.Eventually(t, func() bool { return !b.Healthy() }, time.Second, 10*time.Millisecond)
+		assert.True(t, a.Healthy())
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, a, p.next(r))
+		}
+
+		p.upstreams = []*Upstream{b}
+		assert.Nil(t, p.next(r))
+	})
+	t.Run("checks at most once per interval", func(t *testing.T) {
+		var checks int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&checks, 1)
+			_, _ = w.Write([]byte(`{"status": "healthy"}`))
+		}))
+		defer s.Close()
+
+		u, _ := newUpstream(s.URL, 1)
+		p := newPool(u)
+		p.healthCheck = time.Minute
+
+		r := httptest.NewRequest("GET", "/", nil)
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, u, p.next(r))
+		}
+
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&checks) == 1 }, time.Second, 10*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&checks))
+	})
+
+	t.Run("times out hung checks", func(t *testing.T) {
+		done := make(chan struct{})
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-done:
+			case <-r.Context().Done():
+			}
+		}))
+		defer s.Close()
+		defer close(done)
+
+		u, _ := newUpstream(s.URL, 1)
+		p := newPool(u)
+		p.healthCheck = 50 * time.Millisecond
+
+		r := httptest.NewRequest("GET", "/", nil)
+		assert.Equal(t, u, p.next(r))
+		assert.Eventually(t, func() bool { return !u.Healthy() }, time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,142 @@
+package tea
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-version"
+)
+
+// versionPattern matches a major version path segment (e.g., /v1)
+var versionPattern = regexp.MustCompile(`^/v\d+(/|$)`)
+
+// apiVersion is the metadata for an API version served by the router
+type apiVersion struct {
+	deprecation time.Time
+	sunset      time.Time
+	link        string
+}
+
+// Handle provides a http handler for announcing the deprecation of the version
+func (v *apiVersion) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !v.deprecation.IsZero() {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", v.deprecation.Unix()))
+		}
+
+		if !v.sunset.IsZero() {
+			w.Header().Set("Sunset", v.sunset.UTC().Format(http.TimeFormat))
+		}
+
+		if v.link != "" {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, v.link))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiVersion gets the metadata for a version prefix served by the router
+func (r *Router) apiVersion(prefix string) *apiVersion {
+	v, present := r.versions[prefix]
+	if !present {
+		v = &apiVersion{}
+		r.versions[prefix] = v
+	}
+
+	return v
+}
+
+// VersionOption is a handler for configuring an API version
+type VersionOption func(v *apiVersion)
+
+// WithDeprecation creates a deprecation date option (Deprecation header)
+func WithDeprecation(at time.Time) VersionOption {
+	return func(v *apiVersion) {
+		v.deprecation = at
+	}
+}
+
+// WithSunset creates a sunset date option (Sunset header)
+func WithSunset(at time.Time) VersionOption {
+	return func(v *apiVersion) {
+		v.sunset = at
+	}
+}
+
+// WithDeprecationLink creates a deprecation policy link option
+func WithDeprecationLink(link string) VersionOption {
+	return func(v *apiVersion) {
+		v.link = link
+	}
+}
+
+// WithVersionNegotiation creates an option for negotiating the API version
+// from the Accept header (e.g., application/vnd.pghq+json;version=2) for
+// requests without a version in their path
+func WithVersionNegotiation() RouterOption {
+	return func(r *Router) {
+		r.negotiate = true
+	}
+}
+
+// negotiateVersion rewrites the request path to the version accepted by the client
+func (r *Router) negotiateVersion(w http.ResponseWriter, req *http.Request) *http.Request {
+	rest := strings.TrimPrefix(req.URL.Path, r.servicePrefix)
+	if rest == req.URL.Path && r.servicePrefix != "" || versionPattern.MatchString(rest) {
+		return req
+	}
+
+	w.Header().Add("Vary", "Accept")
+	prefix := acceptedVersion(req)
+	if prefix == "" {
+		prefix = r.version
+	}
+
+	if prefix == "" {
+		return req
+	}
+
+	negotiated := req.Clone(req.Context())
+	negotiated.URL.Path = r.servicePrefix + prefix + rest
+	negotiated.URL.RawPath = ""
+
+	var match mux.RouteMatch
+	matched := r.mux.Match(negotiated, &match)
+	if !matched && match.MatchErr != mux.ErrMethodMismatch || match.MatchErr == mux.ErrNotFound {
+		return req
+	}
+
+	return negotiated
+}
+
+// acceptedVersion gets the version prefix requested by vendor media types in the Accept header
+func acceptedVersion(r *http.Request) string {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil || !strings.HasPrefix(mediaType, "application/vnd.") {
+			continue
+		}
+
+		if v, _ := version.NewVersion(params["version"]); v != nil {
+			return versionPrefix(params["version"])
+		}
+	}
+
+	return ""
+}
+
+// versionPrefix gets the path prefix for the major version of a semver (e.g., /v1)
+func versionPrefix(semver string) string {
+	v, _ := version.NewVersion(semver)
+	if v == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("/v%d", v.Segments()[0])
+}
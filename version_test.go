@@ -0,0 +1,98 @@
+package tea
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter_Version(t *testing.T) {
+	t.Parallel()
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	}
+
+	t.Run("side by side", func(t *testing.T) {
+		r := NewRouter("2.0.0")
+		r.Route("GET", "/tests", ok)
+		r.Version("1.0.0").Route("GET", "/tests", ok)
+
+		for _, path := range []string{"/v1/tests", "/v2/tests"} {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get("Deprecation"))
+		}
+	})
+
+	t.Run("deprecation", func(t *testing.T) {
+		deprecation := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		sunset := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		r := NewRouter("2.0.0")
+		r.Route("GET", "/tests", ok)
+		v1 := r.Version("1.0.0")
+		v1.Route("GET", "/tests", ok)
+		r.Version("1", WithDeprecation(deprecation), WithSunset(sunset), WithDeprecationLink("https://tea.pghq.app/v2"))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/tests", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "@1640995200", w.Header().Get("Deprecation"))
+		assert.Equal(t, "Sun, 01 Jan 2023 00:00:00 GMT", w.Header().Get("Sunset"))
+		assert.Equal(t, `<https://tea.pghq.app/v2>; rel="deprecation"`, w.Header().Get("Link"))
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/v2/tests", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Deprecation"))
+	})
+
+	t.Run("negotiation", func(t *testing.T) {
+		r := NewRouter("2.0.0", WithServicePrefix("/service"), WithVersionNegotiation())
+		r.Route("GET", "/tests", ok)
+		r.Version("1.0.0").Route("GET", "/tests", ok)
+
+		t.Run("accept header", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/service/tests", nil)
+			req.Header.Set("Accept", "text/html, application/vnd.pghq+json; version=1")
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "/service/v1/tests", w.Body.String())
+			assert.Contains(t, w.Header().Values("Vary"), "Accept")
+		})
+
+		t.Run("default version", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/service/tests", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "/service/v2/tests", w.Body.String())
+		})
+
+		t.Run("versioned path", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/service/v2/tests", nil)
+			req.Header.Set("Accept", "application/vnd.pghq+json; version=1")
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "/service/v2/tests", w.Body.String())
+		})
+
+		t.Run("unversioned route", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/service/health/status", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+
+		t.Run("outside service prefix", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/tests", nil))
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		})
+	})
+}